@@ -0,0 +1,318 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+)
+
+const (
+	ServiceSystemd  = "systemd"
+	ServiceWGQuick  = "wg-quick"
+	maxResponseSize = 16 << 20
+)
+
+// The panel validates interface names the same way; the agent checks again before touching files.
+var ifaceNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,14}$`)
+
+// Agent syncs the node with the panel.
+type Agent struct {
+	cfg     *config.Config
+	server  string
+	token   string
+	service string
+	client  *http.Client
+	// managed are the interfaces received so far
+	managed map[string]*managedIface
+}
+
+// managedIface is what the agent remembers about an interface between syncs.
+type managedIface struct {
+	// pending and restart are set when written files have not been loaded yet, and cleared only once
+	// loading them succeeded, so a failed apply is retried on the next sync
+	pending bool
+	restart bool
+	// err is the error of the last failed apply, reported until an apply succeeds
+	err string
+}
+
+// Run parses the agent flags, which override the AGENT_* settings, and syncs until the process is stopped.
+func Run(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	server := fs.String("server", cfg.AgentServer, "URL of the central panel")
+	token := fs.String("token", cfg.AgentToken, "node token issued by the panel")
+	caFile := fs.String("ca", cfg.AgentCAFile, "PEM bundle to verify the panel's certificate with (default: system roots)")
+	interval := fs.String("interval", cfg.AgentInterval, "seconds between syncs")
+	service := fs.String("service", cfg.AgentService, "how interfaces are brought up: systemd or wg-quick")
+	confDir := fs.String("conf-dir", cfg.WGConfDir, "directory the interface configs are written to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *server == "" || *token == "" {
+		fs.Usage()
+		return fmt.Errorf("-server and -token are required")
+	}
+	if *service != ServiceSystemd && *service != ServiceWGQuick {
+		return fmt.Errorf("-service must be %s or %s", ServiceSystemd, ServiceWGQuick)
+	}
+	seconds, err := strconv.Atoi(*interval)
+	if err != nil || seconds < 1 {
+		return fmt.Errorf("invalid interval %q", *interval)
+	}
+	client, err := newClient(*caFile)
+	if err != nil {
+		return err
+	}
+
+	cfg.WGConfDir = *confDir
+	a := &Agent{cfg: cfg, server: strings.TrimRight(*server, "/"), token: *token, service: *service, client: client, managed: map[string]*managedIface{}}
+	log.Printf("[AGENT] syncing with %s every %ds (%s, configs in %s)", a.server, seconds, a.service, cfg.WGConfDir)
+	for {
+		if err := a.Sync(); err != nil {
+			log.Printf("[AGENT] sync failed: %v", err)
+		}
+		time.Sleep(time.Duration(seconds) * time.Second)
+	}
+}
+
+func newClient(caFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// Sync reports the state of the managed interfaces and applies the desired state the panel answers with.
+func (a *Agent) Sync() error {
+	body, err := json.Marshal(a.report())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.server+SyncPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("panel answered %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var desired Desired
+	if err := json.Unmarshal(data, &desired); err != nil {
+		return fmt.Errorf("decode desired state: %w", err)
+	}
+	a.apply(desired)
+	return nil
+}
+
+func (a *Agent) report() Report {
+	hostname, _ := os.Hostname()
+	rep := Report{Hostname: hostname, Version: version(), ConfDir: a.cfg.WGConfDir, ExternalIF: a.cfg.WGExternalIF, WGMode: a.cfg.WGMode, Interfaces: []InterfaceReport{}}
+	for name, m := range a.managed {
+		ifc := a.cfg.ForInterface(name)
+		r := InterfaceReport{Name: name, Up: wireguard.InterfaceUp(ifc), ServiceState: a.serviceState(ifc), Error: m.err}
+		if r.Up {
+			if dump, err := wireguard.ShowDump(ifc); err == nil {
+				r.Dump = dump
+			}
+		}
+		rep.Interfaces = append(rep.Interfaces, r)
+	}
+	return rep
+}
+
+func version() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		return bi.Main.Version
+	}
+	return ""
+}
+
+// apply converges every interface on its desired state and retires the ones no longer assigned.
+func (a *Agent) apply(desired Desired) {
+	seen := map[string]bool{}
+	for _, d := range desired.Interfaces {
+		if !ifaceNameRe.MatchString(d.Name) {
+			log.Printf("[AGENT] ignoring interface with invalid name %q", d.Name)
+			continue
+		}
+		seen[d.Name] = true
+		m := a.managed[d.Name]
+		if m == nil {
+			m = &managedIface{}
+			a.managed[d.Name] = m
+		}
+		if err := a.applyInterface(d, m); err != nil {
+			log.Printf("[AGENT] %s: %v", d.Name, err)
+			m.err = err.Error()
+		} else {
+			m.err = ""
+		}
+	}
+	for name := range a.managed {
+		if seen[name] {
+			continue
+		}
+		ifc := a.cfg.ForInterface(name)
+		if wireguard.InterfaceUp(ifc) {
+			if err := a.control(ifc, "stop"); err != nil {
+				log.Printf("[AGENT] %s: stop unassigned interface: %v", name, err)
+				continue
+			}
+		}
+		for _, path := range []string{confPath(ifc), wireguard.ACLScriptPath(ifc), wireguard.ShapingScriptPath(ifc)} {
+			_ = os.Remove(path)
+		}
+		delete(a.managed, name)
+		log.Printf("[AGENT] %s: no longer assigned to this node; stopped and removed", name)
+	}
+}
+
+func (a *Agent) applyInterface(d DesiredInterface, m *managedIface) error {
+	ifc := a.cfg.ForInterface(d.Name)
+	changed, restart, err := writeChanged(ifc, d.Files)
+	if err != nil {
+		return err
+	}
+	m.pending = m.pending || changed
+	m.restart = m.restart || restart
+	up := wireguard.InterfaceUp(ifc)
+	switch {
+	case d.Up && !up:
+		err = a.control(ifc, "start")
+	case d.Up && m.restart:
+		err = a.control(ifc, "restart")
+	case d.Up && m.pending:
+		// Peers, keys, ACL and rate limits load in place; connected peers keep their sessions
+		if r := wireguard.Apply(ifc, wireguard.ReloadAll); r.Error != "" {
+			err = errors.New(r.Error)
+		}
+	case !d.Up && up:
+		err = a.control(ifc, "stop")
+	}
+	if err != nil {
+		return err
+	}
+	// Running or not, the interface now matches the files: a stopped one loads them on its next start
+	m.pending, m.restart = false, false
+	return nil
+}
+
+// writeChanged writes the files whose content differs from the copy on disk and reports whether any did,
+// and whether the interface config changed beyond what `wg syncconf` loads. Only the files the panel
+// generates for the interface are accepted.
+func writeChanged(ifc *config.Config, files []wireguard.File) (changed, restart bool, err error) {
+	allowed := map[string]bool{}
+	for _, path := range []string{confPath(ifc), wireguard.ACLScriptPath(ifc), wireguard.ShapingScriptPath(ifc)} {
+		allowed[filepath.Base(path)] = true
+	}
+	var changes []wireguard.File
+	for _, f := range files {
+		if !allowed[f.Name] {
+			return false, false, fmt.Errorf("unexpected file %q", f.Name)
+		}
+		cur, err := os.ReadFile(filepath.Join(ifc.WGConfDir, f.Name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, false, err
+		}
+		if err != nil || string(cur) != f.Content {
+			changes = append(changes, f)
+			if f.Name == filepath.Base(confPath(ifc)) && wireguard.NeedsRestart(string(cur), f.Content) {
+				restart = true
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return false, false, nil
+	}
+	if err := wireguard.WriteFiles(ifc, changes); err != nil {
+		return false, false, err
+	}
+	return true, restart, nil
+}
+
+func confPath(ifc *config.Config) string {
+	return filepath.Join(ifc.WGConfDir, ifc.WGInterface+".conf")
+}
+
+// control starts, stops or restarts an interface through systemd, or by running wg-quick on its config
+// file directly, which works inside network namespaces without a unit per namespace.
+func (a *Agent) control(ifc *config.Config, action string) error {
+	if a.service == ServiceSystemd {
+		svc := fmt.Sprintf("%s-quick@%s", ifc.WGMode, ifc.WGInterface)
+		switch action {
+		case "start":
+			return run("systemctl", "--now", "enable", svc)
+		case "stop":
+			return run("systemctl", "--now", "disable", svc)
+		default:
+			return run("systemctl", "restart", svc)
+		}
+	}
+	quick := ifc.WGMode + "-quick"
+	switch action {
+	case "start":
+		return run(quick, "up", confPath(ifc))
+	case "stop":
+		return run(quick, "down", confPath(ifc))
+	default:
+		// best-effort: the interface may have gone down in between
+		_ = run(quick, "down", confPath(ifc))
+		return run(quick, "up", confPath(ifc))
+	}
+}
+
+func (a *Agent) serviceState(ifc *config.Config) string {
+	if a.service == ServiceSystemd {
+		out, _ := exec.Command("systemctl", "is-active", fmt.Sprintf("%s-quick@%s", ifc.WGMode, ifc.WGInterface)).Output()
+		return strings.TrimSpace(string(out))
+	}
+	if wireguard.InterfaceUp(ifc) {
+		return "active"
+	}
+	return "inactive"
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package agent runs the interfaces of a remote node for the central panel: it fetches their desired
+// state over HTTPS, applies it with the local wg-quick tooling and reports the runtime state back.
+package agent
+
+import "github.com/StellaShiina/wireguard-ui/wireguard"
+
+// SyncPath is the panel endpoint agents post their report to.
+const SyncPath = "/agent/v1/sync"
+
+// Report is what an agent sends on every sync.
+type Report struct {
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+	// The agent's WG_CONF_DIR, WG_EXTERNAL_IF and WG_MODE; the panel renders its files with them
+	ConfDir    string            `json:"conf_dir"`
+	ExternalIF string            `json:"external_if"`
+	WGMode     string            `json:"wg_mode"`
+	Interfaces []InterfaceReport `json:"interfaces"`
+}
+
+// InterfaceReport is the state of one interface the agent manages.
+type InterfaceReport struct {
+	Name         string `json:"name"`
+	Up           bool   `json:"up"`
+	ServiceState string `json:"service_state"`
+	// Dump is nil while the interface is down
+	Dump *wireguard.Dump `json:"dump"`
+	// Error is what went wrong applying the interface in the previous sync
+	Error string `json:"error,omitempty"`
+}
+
+// Desired is the panel's answer: every interface assigned to the node. Interfaces the agent managed
+// before and that are missing here are stopped and their files removed.
+type Desired struct {
+	Interfaces []DesiredInterface `json:"interfaces"`
+}
+
+// DesiredInterface is one interface with its generated files and whether it should be running.
+type DesiredInterface struct {
+	Name  string           `json:"name"`
+	Up    bool             `json:"up"`
+	Files []wireguard.File `json:"files"`
+}
@@ -0,0 +1,311 @@
+// Package alerts evaluates the alert rules against the interface, the session log and the traffic
+// history, stores firing and resolved alerts and sends them to the notifiers.
+package alerts
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+)
+
+const (
+	evalInterval = time.Minute
+	// endpointHistory is how far back earlier sessions count as known addresses of a peer
+	endpointHistory = 90 * 24 * time.Hour
+)
+
+// Run evaluates the rules every minute until the process exits.
+func Run(cfg *config.Config) {
+	for {
+		if err := Evaluate(cfg, time.Now()); err != nil {
+			log.Printf("[WG] alert evaluation failed: %v", err)
+		}
+		time.Sleep(evalInterval)
+	}
+}
+
+// Evaluate checks every rule once, opening alerts for conditions that started and resolving those that
+// cleared. Alerts of disabled rules, and of peers a rule no longer covers, are resolved.
+func Evaluate(cfg *config.Config, now time.Time) error {
+	var rules []db.AlertRule
+	if err := db.DB.Find(&rules).Error; err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	var peers []db.Peer
+	if err := db.DB.Select("uuid", "server_uuid", "name", "public_key", "group_uuid", "enabled", "expires_at", "quota_exceeded_at", "created_at").Find(&peers).Error; err != nil {
+		return err
+	}
+	ifaces, err := wireguard.Interfaces(cfg)
+	if err != nil {
+		return err
+	}
+	ifaceNames := map[string]string{}
+	for _, ifc := range ifaces {
+		ifaceNames[ifc.Server.UUID] = ifc.Server.Interface
+	}
+	var active []db.Peer
+	info := map[string]peerInfo{}
+	for _, p := range peers {
+		info[p.UUID] = peerInfo{name: p.Name, iface: ifaceNames[p.ServerUUID]}
+		if p.Active(now) {
+			active = append(active, p)
+		}
+	}
+	// The interfaces are only read when a rule needs them; a down interface has no dump
+	var dumps map[string]*wireguard.Dump
+
+	for _, r := range rules {
+		firing := map[string]string{}
+		var unknown map[string]bool
+		if r.Enabled {
+			targets := ruleTargets(r, active)
+			var err error
+			switch r.Kind {
+			case db.AlertStaleHandshake:
+				if dumps == nil {
+					dumps = map[string]*wireguard.Dump{}
+					for _, ifc := range ifaces {
+						if dump, err := wireguard.ShowDump(ifc.Cfg); err == nil {
+							dumps[ifc.Server.UUID] = dump
+						}
+					}
+				}
+				firing, unknown, err = staleHandshakes(r, targets, dumps, now)
+			case db.AlertEndpointChange:
+				firing, err = endpointChanges(r, targets, now)
+			case db.AlertTrafficSpike:
+				firing, err = trafficSpikes(r, targets, now)
+			}
+			if err != nil {
+				log.Printf("[WG] evaluate alert rule %s failed: %v", r.UUID, err)
+				continue
+			}
+		}
+		if err := transition(cfg, r, firing, unknown, info, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// peerInfo is what announcements need to know about a peer besides the alert.
+type peerInfo struct {
+	name  *string
+	iface string
+}
+
+func ruleTargets(r db.AlertRule, peers []db.Peer) []db.Peer {
+	var out []db.Peer
+	for _, p := range peers {
+		switch {
+		case r.PeerUUID != nil && p.UUID != *r.PeerUUID:
+		case r.GroupUUID != nil && (p.GroupUUID == nil || *p.GroupUUID != *r.GroupUUID):
+		default:
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func uuidsOf(peers []db.Peer) []string {
+	out := make([]string, 0, len(peers))
+	for _, p := range peers {
+		out = append(out, p.UUID)
+	}
+	return out
+}
+
+// staleHandshakes returns the peers whose latest handshake (on the interface, else in the session log,
+// else their creation) is at least r.Minutes old. Peers on a down interface all look stale; they are
+// returned as unknown so that their alerts keep their current state.
+func staleHandshakes(r db.AlertRule, peers []db.Peer, dumps map[string]*wireguard.Dump, now time.Time) (map[string]string, map[string]bool, error) {
+	firing := map[string]string{}
+	unknown := map[string]bool{}
+	var up []db.Peer
+	for _, p := range peers {
+		if dumps[p.ServerUUID] == nil {
+			unknown[p.UUID] = true
+		} else {
+			up = append(up, p)
+		}
+	}
+	peers = up
+	if len(peers) == 0 {
+		return firing, unknown, nil
+	}
+	runtime := map[string]*time.Time{}
+	for _, dump := range dumps {
+		for _, p := range dump.Peers {
+			runtime[p.PublicKey] = p.LatestHandshake
+		}
+	}
+	type row struct {
+		PeerUUID string
+		Last     time.Time
+	}
+	var rows []row
+	err := db.DB.Model(&db.PeerSession{}).Select("peer_uuid, max(last_handshake_at) AS last").
+		Where("peer_uuid IN ?", uuidsOf(peers)).Group("peer_uuid").Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	logged := map[string]time.Time{}
+	for _, r := range rows {
+		logged[r.PeerUUID] = r.Last
+	}
+
+	limit := time.Duration(r.Minutes) * time.Minute
+	for _, p := range peers {
+		if hs := runtime[p.PublicKey]; hs != nil {
+			if now.Sub(*hs) >= limit {
+				firing[p.UUID] = fmt.Sprintf("no handshake since %s", hs.UTC().Format(time.RFC3339))
+			}
+		} else if last, ok := logged[p.UUID]; ok {
+			if now.Sub(last) >= limit {
+				firing[p.UUID] = fmt.Sprintf("no handshake since %s", last.UTC().Format(time.RFC3339))
+			}
+		} else if now.Sub(p.CreatedAt) >= limit {
+			firing[p.UUID] = fmt.Sprintf("no handshake since the peer was added on %s", p.CreatedAt.UTC().Format(time.RFC3339))
+		}
+	}
+	return firing, unknown, nil
+}
+
+// endpointChanges returns the peers whose current session started less than r.Minutes ago from an
+// address none of their sessions of the last 90 days used. A peer's first session is not a change.
+func endpointChanges(r db.AlertRule, peers []db.Peer, now time.Time) (map[string]string, error) {
+	firing := map[string]string{}
+	if len(peers) == 0 {
+		return firing, nil
+	}
+	var current []db.PeerSession
+	err := db.DB.Where("ended_at IS NULL AND started_at >= ? AND peer_uuid IN ?", now.Add(-time.Duration(r.Minutes)*time.Minute), uuidsOf(peers)).
+		Find(&current).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range current {
+		var earlier []string
+		err := db.DB.Model(&db.PeerSession{}).Distinct("endpoint").
+			Where("peer_uuid = ? AND id <> ? AND started_at >= ?", s.PeerUUID, s.ID, now.Add(-endpointHistory)).
+			Pluck("endpoint", &earlier).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(earlier) == 0 {
+			continue
+		}
+		ip := endpointIP(s.Endpoint)
+		known := map[string]bool{}
+		var list []string
+		for _, e := range earlier {
+			if k := endpointIP(e); !known[k] {
+				known[k] = true
+				list = append(list, k)
+			}
+		}
+		if !known[ip] {
+			firing[s.PeerUUID] = fmt.Sprintf("connected from new address %s (known: %s)", s.Endpoint, strings.Join(list, ", "))
+		}
+	}
+	return firing, nil
+}
+
+func endpointIP(endpoint string) string {
+	if ap, err := netip.ParseAddrPort(endpoint); err == nil {
+		return ap.Addr().Unmap().String()
+	}
+	return endpoint
+}
+
+// trafficSpikes returns the peers whose traffic in both directions over the last r.Minutes exceeds r.Bytes.
+func trafficSpikes(r db.AlertRule, peers []db.Peer, now time.Time) (map[string]string, error) {
+	firing := map[string]string{}
+	if len(peers) == 0 || r.Bytes == nil {
+		return firing, nil
+	}
+	type row struct {
+		PeerUUID string
+		Bytes    int64
+	}
+	var rows []row
+	err := db.DB.Model(&db.PeerUsage{}).Select("peer_uuid, sum(rx_bytes + tx_bytes) AS bytes").
+		Where("resolution = ? AND bucket >= ? AND peer_uuid IN ?", db.UsageRaw, now.Add(-time.Duration(r.Minutes)*time.Minute), uuidsOf(peers)).
+		Group("peer_uuid").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if row.Bytes > *r.Bytes {
+			firing[row.PeerUUID] = fmt.Sprintf("%d bytes in the last %d minutes (limit %d)", row.Bytes, r.Minutes, *r.Bytes)
+		}
+	}
+	return firing, nil
+}
+
+// transition stores new and resolved alerts of a rule and announces them. Open alerts of unknown peers are kept.
+func transition(cfg *config.Config, r db.AlertRule, firing map[string]string, unknown map[string]bool, info map[string]peerInfo, now time.Time) error {
+	var open []db.Alert
+	if err := db.DB.Where("rule_uuid = ? AND resolved_at IS NULL", r.UUID).Find(&open).Error; err != nil {
+		return err
+	}
+	isOpen := map[string]bool{}
+	for _, a := range open {
+		isOpen[a.PeerUUID] = true
+		if _, still := firing[a.PeerUUID]; still || unknown[a.PeerUUID] {
+			continue
+		}
+		if err := db.DB.Model(&db.Alert{}).Where("id = ?", a.ID).Updates(map[string]any{"state": db.AlertResolved, "resolved_at": now}).Error; err != nil {
+			return err
+		}
+		a.State = db.AlertResolved
+		a.ResolvedAt = &now
+		announce(cfg, r, a, info[a.PeerUUID])
+	}
+	for uuid, detail := range firing {
+		if isOpen[uuid] {
+			continue
+		}
+		a := db.Alert{RuleUUID: r.UUID, PeerUUID: uuid, Kind: r.Kind, State: db.AlertFiring, Detail: detail, FiredAt: now}
+		if err := db.DB.Omit("peer_name", "rule_name").Create(&a).Error; err != nil {
+			return err
+		}
+		announce(cfg, r, a, info[uuid])
+	}
+	return nil
+}
+
+func announce(cfg *config.Config, r db.AlertRule, a db.Alert, peer peerInfo) {
+	a.PeerName = peer.name
+	a.RuleName = &r.Name
+	typ := events.AlertFiring
+	if a.State == db.AlertResolved {
+		typ = events.AlertResolved
+	}
+	events.Publish(events.Event{Type: typ, Interface: peer.iface, PeerUUID: a.PeerUUID, Data: a})
+
+	var notifiers []db.AlertNotifier
+	q := db.DB.Where("enabled")
+	if len(r.Notifiers) > 0 {
+		q = q.Where("uuid IN ?", []string(r.Notifiers))
+	}
+	if err := q.Find(&notifiers).Error; err != nil {
+		log.Printf("[WG] query alert notifiers failed: %v", err)
+		return
+	}
+	for _, n := range notifiers {
+		if err := Notify(cfg, n, a); err != nil {
+			log.Printf("[WG] alert notifier %s failed: %v", n.UUID, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/mailer"
+	"github.com/StellaShiina/wireguard-ui/webhooks"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Summary is the one-line description of an alert used as chat message and email subject,
+// e.g. "[FIRING] Site routers: branch-3 no handshake since 2024-05-01T10:00:00Z".
+func Summary(a db.Alert) string {
+	peer := a.PeerUUID
+	if a.PeerName != nil && *a.PeerName != "" {
+		peer = *a.PeerName
+	}
+	rule := a.Kind
+	if a.RuleName != nil && *a.RuleName != "" {
+		rule = *a.RuleName
+	}
+	return fmt.Sprintf("[%s] %s: %s %s", strings.ToUpper(a.State), rule, peer, a.Detail)
+}
+
+// Notify sends one alert through one notifier.
+func Notify(cfg *config.Config, n db.AlertNotifier, a db.Alert) error {
+	switch n.Kind {
+	case db.NotifierWebhook:
+		body, err := json.Marshal(map[string]any{"alert": a, "text": Summary(a)})
+		if err != nil {
+			return err
+		}
+		headers := map[string]string{}
+		if n.Secret != nil && *n.Secret != "" {
+			ts := time.Now().Unix()
+			headers["X-WGUI-Timestamp"] = strconv.FormatInt(ts, 10)
+			headers["X-WGUI-Signature"] = webhooks.Sign(*n.Secret, ts, body)
+		}
+		return post(n.Target, body, headers)
+	case db.NotifierChat:
+		body, err := json.Marshal(map[string]string{"text": Summary(a)})
+		if err != nil {
+			return err
+		}
+		return post(n.Target, body, nil)
+	case db.NotifierEmail:
+		text := fmt.Sprintf("%s\n\nRule: %s\nPeer: %s\nState: %s\nFired: %s\n", Summary(a), a.RuleUUID, a.PeerUUID, a.State, a.FiredAt.UTC().Format(time.RFC3339))
+		if a.ResolvedAt != nil {
+			text += fmt.Sprintf("Resolved: %s\n", a.ResolvedAt.UTC().Format(time.RFC3339))
+		}
+		return mailer.Send(cfg, mailer.Message{From: cfg.SMTPFrom, To: n.Target, Subject: Summary(a), Text: text})
+	default:
+		return fmt.Errorf("unknown notifier kind %q", n.Kind)
+	}
+}
+
+func post(url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wireguard-ui-alerts")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	return nil
+}
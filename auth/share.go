@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Share tokens are signed with a key derived from JWT_SECRET rather than the secret itself,
+// so a share token can never be replayed as a session cookie.
+func shareKey() []byte {
+	sum := sha256.Sum256([]byte("share-link:" + appConfig.JWTSecret))
+	return sum[:]
+}
+
+// GenerateShareToken signs a token for a share link; the link uuid is the token ID and the peer uuid its subject.
+func GenerateShareToken(linkUUID, peerUUID string, expiresAt time.Time) (string, error) {
+	claims := jwt.RegisteredClaims{
+		ID:        linkUUID,
+		Subject:   peerUUID,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(shareKey())
+}
+
+// ValidateShareToken checks the signature and expiry of a share token.
+// Use counts and revocation live in the database and are checked by the caller.
+func ValidateShareToken(tokenString string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return shareKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.ID == "" || claims.Subject == "" {
+		return nil, errors.New("invalid share token")
+	}
+	return claims, nil
+}
@@ -1,24 +1,48 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
 type Config struct {
-	AuthUsername string
-	AuthPassword string
-	JWTSecret    string
-	DBHost       string
-	DBPort       string
-	DBUser       string
-	DBPassword   string
-	DBName       string
-	DBSSLMode    string
-	WGConfDir    string
-	WGClientsDir string
-	WGExternalIF string
-	WGInterface  string
-	WGMode       string
-	UIAddr       string
-	UIPort       string
+	AuthUsername         string
+	AuthPassword         string
+	JWTSecret            string
+	DBHost               string
+	DBPort               string
+	DBUser               string
+	DBPassword           string
+	DBName               string
+	DBSSLMode            string
+	WGConfDir            string
+	WGClientsDir         string
+	WGExternalIF         string
+	WGInterface          string
+	WGMode               string
+	WGApplyMode          string
+	UIAddr               string
+	UIPort               string
+	SMTPHost             string
+	SMTPPort             string
+	SMTPUsername         string
+	SMTPPassword         string
+	SMTPFrom             string
+	SMTPTLS              string
+	EmailTmplDir         string
+	StatsInterval        string
+	StatsRawRetention    string
+	StatsHourlyRetention string
+	StatsDailyRetention  string
+	SessionRetention     string
+	MetricsToken         string
+	AgentServer          string
+	AgentToken           string
+	AgentCAFile          string
+	AgentInterval        string
+	AgentService         string
+	// WGNode is the node whose agent runs WGInterface, empty for this host; it is set per interface, not from the environment
+	WGNode string
 }
 
 const (
@@ -35,32 +59,104 @@ const (
 	DefaultWGConfDir    = "/etc/amnezia/amneziawg"
 	DefaultWGClientsDir = "/etc/amnezia/amneziawg/clients"
 	DefaultWGExternalIF = "" // Auto-detect if not set
-	DefaultWGInterface  = "awg0"
-	DefaultWGMode       = "awg"
+	// Interface the existing server row is adopted as, and the one the API works on outside /api/v1/interfaces/:name
+	DefaultWGInterface = "awg0"
+	DefaultWGMode      = "awg"
+	// How peer and server changes reach a running interface: auto hot-applies them with `wg syncconf`,
+	// manual only writes the config files until POST /api/v1/wg/apply
+	DefaultWGApplyMode = "auto"
 	// Frontend listening address/port (service binding). UI_ADDR takes precedence, then UI_PORT
 	DefaultUIAddr = "localhost"
 	DefaultUIPort = "60000"
+	// Outgoing mail for peer configs; SMTP_HOST empty disables email delivery. SMTP_TLS is starttls, tls or none (e.g. for MailHog on localhost:1025)
+	DefaultSMTPHost     = ""
+	DefaultSMTPPort     = "587"
+	DefaultSMTPUsername = ""
+	DefaultSMTPPassword = ""
+	DefaultSMTPFrom     = "wireguard-ui@localhost"
+	DefaultSMTPTLS      = "starttls"
+	// Directory with custom email templates; built-in templates are used for any file missing there
+	DefaultEmailTmplDir = ""
+	// Traffic accounting: seconds between samples of the peer counters (0 disables the collector),
+	// then how long raw samples (hours), hourly and daily rollups (days) are kept
+	DefaultStatsInterval        = "60"
+	DefaultStatsRawRetention    = "48"
+	DefaultStatsHourlyRetention = "90"
+	DefaultStatsDailyRetention  = "730"
+	// Days of connection sessions to keep
+	DefaultSessionRetention = "365"
+	// Bearer token required by /metrics; empty leaves the endpoint open (e.g. when it is only reachable from the scraper)
+	DefaultMetricsToken = ""
+	// Agent mode (`wireguard-ui agent`): URL of the central panel, the node token it issued, an optional CA
+	// bundle to verify the panel with, seconds between syncs, and how interfaces are brought up: systemd
+	// (<WG_MODE>-quick@<iface>) or wg-quick (runs <WG_MODE>-quick directly, e.g. inside a network namespace)
+	DefaultAgentServer   = ""
+	DefaultAgentToken    = ""
+	DefaultAgentCAFile   = ""
+	DefaultAgentInterval = "15"
+	DefaultAgentService  = "systemd"
 )
 
 func LoadConfig() *Config {
 	return &Config{
-		AuthUsername: getEnvOrDefault("AUTH_USERNAME", DefaultAuthUsername),
-		AuthPassword: getEnvOrDefault("AUTH_PASSWORD", DefaultAuthPassword),
-		JWTSecret:    getEnvOrDefault("JWT_SECRET", DefaultJWTSecret),
-		DBHost:       getEnvOrDefault("DB_HOST", DefaultDBHost),
-		DBPort:       getEnvOrDefault("DB_PORT", DefaultDBPort),
-		DBUser:       getEnvOrDefault("DB_USER", DefaultDBUser),
-		DBPassword:   getEnvOrDefault("DB_PASSWORD", DefaultDBPassword),
-		DBName:       getEnvOrDefault("DB_NAME", DefaultDBName),
-		DBSSLMode:    getEnvOrDefault("DB_SSL_MODE", DefaultDBSSLMode),
-		WGConfDir:    getEnvOrDefault("WG_CONF_DIR", DefaultWGConfDir),
-		WGClientsDir: getEnvOrDefault("WG_CLIENTS_DIR", DefaultWGClientsDir),
-		WGExternalIF: getEnvOrDefault("WG_EXTERNAL_IF", DefaultWGExternalIF),
-		WGInterface:  getEnvOrDefault("WG_INTERFACE", DefaultWGInterface),
-		WGMode:       getEnvOrDefault("WG_MODE", DefaultWGMode),
-		UIAddr:       getEnvOrDefault("UI_ADDR", DefaultUIAddr),
-		UIPort:       getEnvOrDefault("UI_PORT", DefaultUIPort),
+		AuthUsername:         getEnvOrDefault("AUTH_USERNAME", DefaultAuthUsername),
+		AuthPassword:         getEnvOrDefault("AUTH_PASSWORD", DefaultAuthPassword),
+		JWTSecret:            getEnvOrDefault("JWT_SECRET", DefaultJWTSecret),
+		DBHost:               getEnvOrDefault("DB_HOST", DefaultDBHost),
+		DBPort:               getEnvOrDefault("DB_PORT", DefaultDBPort),
+		DBUser:               getEnvOrDefault("DB_USER", DefaultDBUser),
+		DBPassword:           getEnvOrDefault("DB_PASSWORD", DefaultDBPassword),
+		DBName:               getEnvOrDefault("DB_NAME", DefaultDBName),
+		DBSSLMode:            getEnvOrDefault("DB_SSL_MODE", DefaultDBSSLMode),
+		WGConfDir:            getEnvOrDefault("WG_CONF_DIR", DefaultWGConfDir),
+		WGClientsDir:         getEnvOrDefault("WG_CLIENTS_DIR", DefaultWGClientsDir),
+		WGExternalIF:         getEnvOrDefault("WG_EXTERNAL_IF", DefaultWGExternalIF),
+		WGInterface:          getEnvOrDefault("WG_INTERFACE", DefaultWGInterface),
+		WGMode:               getEnvOrDefault("WG_MODE", DefaultWGMode),
+		WGApplyMode:          getEnvOrDefault("WG_APPLY_MODE", DefaultWGApplyMode),
+		UIAddr:               getEnvOrDefault("UI_ADDR", DefaultUIAddr),
+		UIPort:               getEnvOrDefault("UI_PORT", DefaultUIPort),
+		SMTPHost:             getEnvOrDefault("SMTP_HOST", DefaultSMTPHost),
+		SMTPPort:             getEnvOrDefault("SMTP_PORT", DefaultSMTPPort),
+		SMTPUsername:         getEnvOrDefault("SMTP_USERNAME", DefaultSMTPUsername),
+		SMTPPassword:         getEnvOrDefault("SMTP_PASSWORD", DefaultSMTPPassword),
+		SMTPFrom:             getEnvOrDefault("SMTP_FROM", DefaultSMTPFrom),
+		SMTPTLS:              getEnvOrDefault("SMTP_TLS", DefaultSMTPTLS),
+		EmailTmplDir:         getEnvOrDefault("EMAIL_TEMPLATE_DIR", DefaultEmailTmplDir),
+		StatsInterval:        getEnvOrDefault("STATS_INTERVAL_SECONDS", DefaultStatsInterval),
+		StatsRawRetention:    getEnvOrDefault("STATS_RAW_RETENTION_HOURS", DefaultStatsRawRetention),
+		StatsHourlyRetention: getEnvOrDefault("STATS_HOURLY_RETENTION_DAYS", DefaultStatsHourlyRetention),
+		StatsDailyRetention:  getEnvOrDefault("STATS_DAILY_RETENTION_DAYS", DefaultStatsDailyRetention),
+		SessionRetention:     getEnvOrDefault("SESSION_RETENTION_DAYS", DefaultSessionRetention),
+		MetricsToken:         getEnvOrDefault("METRICS_TOKEN", DefaultMetricsToken),
+		AgentServer:          getEnvOrDefault("AGENT_SERVER", DefaultAgentServer),
+		AgentToken:           getEnvOrDefault("AGENT_TOKEN", DefaultAgentToken),
+		AgentCAFile:          getEnvOrDefault("AGENT_CA_FILE", DefaultAgentCAFile),
+		AgentInterval:        getEnvOrDefault("AGENT_INTERVAL_SECONDS", DefaultAgentInterval),
+		AgentService:         getEnvOrDefault("AGENT_SERVICE", DefaultAgentService),
+	}
+}
+
+// Validate rejects settings whose typos would otherwise silently fall back to a different behaviour.
+func (c *Config) Validate() error {
+	if c.WGApplyMode != "auto" && c.WGApplyMode != "manual" {
+		return fmt.Errorf("WG_APPLY_MODE must be auto or manual, not %q", c.WGApplyMode)
 	}
+	return nil
+}
+
+// ForInterface returns a copy of the config for another managed interface of this host. WGInterface is
+// the only per-interface setting; file names, services and firewall chains are all derived from it.
+func (c *Config) ForInterface(name string) *Config {
+	cp := *c
+	cp.WGInterface = name
+	cp.WGNode = ""
+	return &cp
+}
+
+// Remote reports whether the interface runs on a node agent instead of this host.
+func (c *Config) Remote() bool {
+	return c.WGNode != ""
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -0,0 +1,28 @@
+package db
+
+import "time"
+
+// ACLRule is one firewall rule for traffic sent by a peer, or by every member of a group.
+// Exactly one of PeerUUID and GroupUUID is set.
+type ACLRule struct {
+	UUID      string  `gorm:"type:uuid;primaryKey" json:"UUID"`
+	PeerUUID  *string `gorm:"type:uuid" json:"PeerUUID"`
+	GroupUUID *string `gorm:"type:uuid" json:"GroupUUID"`
+	// Position orders the rules of one peer or group; lower values are checked first
+	Position int    `gorm:"not null" json:"Position"`
+	Action   string `gorm:"not null" json:"Action"`
+	// Destination is nil for "anywhere"
+	Destination *string `gorm:"type:cidr" json:"Destination"`
+	Protocol    string  `gorm:"not null" json:"Protocol"`
+	// Ports is a comma-separated list of ports and lo-hi ranges; only valid for tcp and udp
+	Ports       *string   `json:"Ports"`
+	Description *string   `json:"Description"`
+	CreatedAt   time.Time `gorm:"not null" json:"CreatedAt"`
+}
+
+func (ACLRule) TableName() string { return "acl_rule" }
+
+const (
+	ACLAccept = "accept"
+	ACLDrop   = "drop"
+)
@@ -0,0 +1,75 @@
+package db
+
+import "time"
+
+// Alert rule kinds
+const (
+	// AlertStaleHandshake fires when a peer has had no handshake for Minutes
+	AlertStaleHandshake = "stale_handshake"
+	// AlertEndpointChange fires for Minutes after a peer connects from an IP address none of its earlier sessions used
+	AlertEndpointChange = "endpoint_change"
+	// AlertTrafficSpike fires while a peer's traffic over the last Minutes exceeds Bytes
+	AlertTrafficSpike = "traffic_spike"
+)
+
+// Notifier kinds
+const (
+	// NotifierWebhook posts the alert as JSON, signed like the event webhooks when a secret is set
+	NotifierWebhook = "webhook"
+	// NotifierChat posts {"text": ...}, understood by Slack, Mattermost and Rocket.Chat incoming webhooks
+	NotifierChat = "chat"
+	// NotifierEmail mails the alert through the configured SMTP server
+	NotifierEmail = "email"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertRule is evaluated for one peer, the members of one group, or every peer when both are nil.
+// Notifiers lists notifier UUIDs; empty means every enabled notifier.
+type AlertRule struct {
+	UUID      string     `gorm:"type:uuid;primaryKey" json:"UUID"`
+	Name      string     `gorm:"not null" json:"Name"`
+	PeerUUID  *string    `gorm:"type:uuid" json:"PeerUUID"`
+	GroupUUID *string    `gorm:"type:uuid" json:"GroupUUID"`
+	Kind      string     `gorm:"not null" json:"Kind"`
+	Minutes   int        `gorm:"not null" json:"Minutes"`
+	Bytes     *int64     `json:"Bytes"`
+	Notifiers StringList `gorm:"type:jsonb;not null" json:"Notifiers"`
+	Enabled   bool       `gorm:"not null" json:"Enabled"`
+	CreatedAt time.Time  `gorm:"not null" json:"CreatedAt"`
+}
+
+func (AlertRule) TableName() string { return "alert_rule" }
+
+// AlertNotifier is a destination for alert notifications. Target is a URL or an email address.
+type AlertNotifier struct {
+	UUID      string    `gorm:"type:uuid;primaryKey" json:"UUID"`
+	Name      string    `gorm:"not null" json:"Name"`
+	Kind      string    `gorm:"not null" json:"Kind"`
+	Target    string    `gorm:"not null" json:"Target"`
+	Secret    *string   `json:"-"`
+	Enabled   bool      `gorm:"not null" json:"Enabled"`
+	CreatedAt time.Time `gorm:"not null" json:"CreatedAt"`
+}
+
+func (AlertNotifier) TableName() string { return "alert_notifier" }
+
+// Alert is one firing of a rule for one peer; ResolvedAt is nil while it fires.
+type Alert struct {
+	ID         int64      `gorm:"primaryKey" json:"ID"`
+	RuleUUID   string     `gorm:"type:uuid;not null" json:"RuleUUID"`
+	PeerUUID   string     `gorm:"type:uuid;not null" json:"PeerUUID"`
+	PeerName   *string    `gorm:"->" json:"PeerName"`
+	RuleName   *string    `gorm:"->" json:"RuleName"`
+	Kind       string     `gorm:"not null" json:"Kind"`
+	State      string     `gorm:"not null" json:"State"`
+	Detail     string     `gorm:"not null" json:"Detail"`
+	FiredAt    time.Time  `gorm:"not null" json:"FiredAt"`
+	ResolvedAt *time.Time `json:"ResolvedAt"`
+}
+
+func (Alert) TableName() string { return "alert" }
@@ -0,0 +1,30 @@
+package db
+
+import "time"
+
+// AuditLog is one entry of the audit trail. PeerUUID is kept after the peer itself is deleted.
+type AuditLog struct {
+	ID        int64     `gorm:"primaryKey" json:"ID"`
+	CreatedAt time.Time `gorm:"not null;default:now()" json:"CreatedAt"`
+	Actor     *string   `json:"Actor"`
+	Action    string    `gorm:"not null" json:"Action"`
+	PeerUUID  *string   `gorm:"type:uuid" json:"PeerUUID"`
+	Detail    *string   `json:"Detail"`
+}
+
+func (AuditLog) TableName() string { return "audit_log" }
+
+// RecordAudit appends an audit entry; empty actor, peerUUID or detail are stored as NULL.
+func RecordAudit(actor, action, peerUUID, detail string) error {
+	entry := AuditLog{Action: action, CreatedAt: time.Now()}
+	if actor != "" {
+		entry.Actor = &actor
+	}
+	if peerUUID != "" {
+		entry.PeerUUID = &peerUUID
+	}
+	if detail != "" {
+		entry.Detail = &detail
+	}
+	return DB.Create(&entry).Error
+}
@@ -1,17 +1,23 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
+// Server is one managed interface with its listening port, subnets and key pair.
 type Server struct {
-	UUID       string `gorm:"type:uuid;primaryKey" json:"UUID"`
+	UUID string `gorm:"type:uuid;primaryKey" json:"UUID"`
+	// Interface is the name of the WireGuard interface, e.g. wg0; it names the config file and service
+	Interface  string `gorm:"not null" json:"Interface"`
 	PublicIP   string `gorm:"type:inet;not null" json:"PublicIP"`
 	Port       int    `gorm:"not null" json:"Port"`
 	EnableIPv6 bool   `gorm:"not null" json:"EnableIPv6"`
@@ -19,15 +25,57 @@ type Server struct {
 	SubnetV6   string `gorm:"type:cidr;not null" json:"SubnetV6"`
 	PrivateKey string `gorm:"not null" json:"-"`
 	PublicKey  string `gorm:"not null" json:"-"`
+	// A staged key rotation: the next key pair, handed out in client configs on request until it is activated
+	PendingPrivateKey *string    `json:"-"`
+	PendingPublicKey  *string    `json:"-"`
+	KeyStagedAt       *time.Time `json:"KeyStagedAt"`
+	// NodeUUID is the node whose agent runs the interface; nil for this host
+	NodeUUID *string `gorm:"type:uuid" json:"NodeUUID"`
+	// DesiredUp is whether the agent keeps a remote interface running; local interfaces are started and stopped directly
+	DesiredUp bool `gorm:"not null;default:true" json:"DesiredUp"`
 }
 
 type Peer struct {
-	UUID       string  `gorm:"type:uuid;primaryKey" json:"UUID"`
+	UUID string `gorm:"type:uuid;primaryKey" json:"UUID"`
+	// ServerUUID is the interface the peer belongs to
+	ServerUUID string  `gorm:"type:uuid;not null" json:"ServerUUID"`
 	IPv4       *string `gorm:"type:cidr;unique" json:"IPv4"`
 	IPv6       *string `gorm:"type:cidr;unique" json:"IPv6"`
-	PrivateKey string  `gorm:"not null" json:"-"`
-	PublicKey  string  `gorm:"not null" json:"-"`
-	Name       *string `json:"Name"`
+	// PrivateKey is nil for bring-your-own-key peers, whose private key never leaves the client
+	PrivateKey   *string `json:"-"`
+	PublicKey    string  `gorm:"not null" json:"-"`
+	PresharedKey *string `json:"-"`
+	Name         *string `json:"Name"`
+	// Disabled peers keep their row and address but are left out of the server configuration
+	Enabled bool `gorm:"not null" json:"Enabled"`
+
+	GroupUUID *string    `gorm:"type:uuid" json:"GroupUUID"`
+	Group     *PeerGroup `gorm:"foreignKey:GroupUUID" json:"Group,omitempty"`
+	// Client-side overrides of the group defaults
+	AllowedIPs          *string    `json:"AllowedIPs"`
+	DNS                 *string    `json:"DNS"`
+	PersistentKeepalive *int       `json:"PersistentKeepalive"`
+	MTU                 *int       `json:"MTU"`
+	ExpiresAt           *time.Time `json:"ExpiresAt"`
+	// Firewall overrides of the group defaults
+	Isolated   *bool   `json:"Isolated"`
+	ACLDefault *string `json:"ACLDefault"`
+	// Rate limits in kbit/s as seen from the peer; nil means unlimited
+	RateUpKbit   *int `json:"RateUpKbit"`
+	RateDownKbit *int `json:"RateDownKbit"`
+	// Data quota overrides of the group defaults
+	QuotaBytes     *int64  `json:"QuotaBytes"`
+	QuotaDirection *string `json:"QuotaDirection"`
+	QuotaPeriod    *string `json:"QuotaPeriod"`
+	// QuotaExceededAt is set while the peer is suspended for exceeding its quota
+	QuotaExceededAt *time.Time `json:"QuotaExceededAt"`
+	// Quota is filled in by the API from accounted traffic
+	Quota *QuotaStatus `gorm:"-" json:"Quota,omitempty"`
+
+	CreatedAt time.Time  `gorm:"not null" json:"CreatedAt"`
+	Tags      StringList `gorm:"type:jsonb;not null" json:"Tags"`
+	// Email is where the peer's config is sent
+	Email *string `json:"Email"`
 }
 
 func (Server) TableName() string { return "server" }
@@ -40,5 +88,33 @@ func Init(cfg *config.Config) error {
 		return err
 	}
 	DB = db
-	return nil
+	// A database from before multiple interfaces has one unnamed server row: it is the configured interface
+	return DB.Model(&Server{}).Where("interface IS NULL").Update("interface", cfg.WGInterface).Error
+}
+
+// IsUniqueViolation reports whether err is Postgres rejecting a value that a unique index already holds.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// Servers returns every managed interface, local and remote, ordered by name.
+func Servers() ([]Server, error) {
+	var servers []Server
+	err := DB.Order("interface").Find(&servers).Error
+	return servers, err
+}
+
+// ServerByInterface returns the server record of an interface, or gorm.ErrRecordNotFound.
+func ServerByInterface(name string) (Server, error) {
+	var s Server
+	err := DB.Where("interface = ?", name).First(&s).Error
+	return s, err
+}
+
+// OnServer is a query scope limiting peers to one interface: DB.Scopes(OnServer(s.UUID)).Find(&peers).
+func OnServer(serverUUID string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("peer.server_uuid = ?", serverUUID)
+	}
 }
@@ -0,0 +1,16 @@
+package db
+
+import "time"
+
+// EmailDelivery records one attempt to send a peer's config by email.
+type EmailDelivery struct {
+	ID        int64     `gorm:"primaryKey" json:"ID"`
+	PeerUUID  string    `gorm:"type:uuid;not null" json:"PeerUUID"`
+	CreatedAt time.Time `gorm:"not null" json:"CreatedAt"`
+	Recipient string    `gorm:"not null" json:"Recipient"`
+	Subject   string    `gorm:"not null" json:"Subject"`
+	Status    string    `gorm:"not null" json:"Status"`
+	Error     *string   `json:"Error"`
+}
+
+func (EmailDelivery) TableName() string { return "email_delivery" }
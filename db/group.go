@@ -0,0 +1,103 @@
+package db
+
+import "time"
+
+// PeerGroup holds client defaults shared by its member peers.
+type PeerGroup struct {
+	UUID                string  `gorm:"type:uuid;primaryKey" json:"UUID"`
+	Name                string  `gorm:"not null;unique" json:"Name"`
+	AllowedIPs          *string `json:"AllowedIPs"`
+	DNS                 *string `json:"DNS"`
+	PersistentKeepalive *int    `json:"PersistentKeepalive"`
+	MTU                 *int    `json:"MTU"`
+	// Isolated blocks traffic between member peers and any other peer
+	Isolated *bool `json:"Isolated"`
+	// ACLDefault is what happens to member traffic that matches no ACL rule ("accept" or "drop")
+	ACLDefault *string `json:"ACLDefault"`
+	// Data quota for each member: limit in bytes, counted traffic ("rx", "tx", "total") and reset period
+	QuotaBytes     *int64  `json:"QuotaBytes"`
+	QuotaDirection *string `json:"QuotaDirection"`
+	QuotaPeriod    *string `json:"QuotaPeriod"`
+	// ExpiryDays sets ExpiresAt on peers that join the group without an expiry of their own
+	ExpiryDays *int `json:"ExpiryDays"`
+}
+
+func (PeerGroup) TableName() string { return "peer_group" }
+
+// PeerSettings are the client settings of a peer after group inheritance.
+// Zero values mean "not set"; the config generator falls back to its defaults.
+type PeerSettings struct {
+	AllowedIPs          string
+	DNS                 string
+	PersistentKeepalive int
+	MTU                 int
+	Isolated            bool
+	ACLDefault          string
+	QuotaBytes          int64
+	QuotaDirection      string
+	QuotaPeriod         string
+}
+
+// Settings merges the peer's own overrides over its group's defaults. Group must be preloaded.
+func (p Peer) Settings() PeerSettings {
+	var st PeerSettings
+	if g := p.Group; g != nil {
+		st.AllowedIPs = strOr(g.AllowedIPs, st.AllowedIPs)
+		st.DNS = strOr(g.DNS, st.DNS)
+		st.PersistentKeepalive = intOr(g.PersistentKeepalive, st.PersistentKeepalive)
+		st.MTU = intOr(g.MTU, st.MTU)
+		st.Isolated = boolOr(g.Isolated, st.Isolated)
+		st.ACLDefault = strOr(g.ACLDefault, st.ACLDefault)
+		st.QuotaBytes = int64Or(g.QuotaBytes, st.QuotaBytes)
+		st.QuotaDirection = strOr(g.QuotaDirection, st.QuotaDirection)
+		st.QuotaPeriod = strOr(g.QuotaPeriod, st.QuotaPeriod)
+	}
+	st.AllowedIPs = strOr(p.AllowedIPs, st.AllowedIPs)
+	st.DNS = strOr(p.DNS, st.DNS)
+	st.PersistentKeepalive = intOr(p.PersistentKeepalive, st.PersistentKeepalive)
+	st.MTU = intOr(p.MTU, st.MTU)
+	st.Isolated = boolOr(p.Isolated, st.Isolated)
+	st.ACLDefault = strOr(p.ACLDefault, st.ACLDefault)
+	st.QuotaBytes = int64Or(p.QuotaBytes, st.QuotaBytes)
+	st.QuotaDirection = strOr(p.QuotaDirection, st.QuotaDirection)
+	st.QuotaPeriod = strOr(p.QuotaPeriod, st.QuotaPeriod)
+	return st
+}
+
+// Expired reports whether the peer's expiry time has passed.
+func (p Peer) Expired(now time.Time) bool {
+	return p.ExpiresAt != nil && !p.ExpiresAt.After(now)
+}
+
+// Active reports whether the peer belongs in the server configuration.
+func (p Peer) Active(now time.Time) bool {
+	return p.Enabled && !p.Expired(now) && p.QuotaExceededAt == nil
+}
+
+func strOr(v *string, def string) string {
+	if v == nil || *v == "" {
+		return def
+	}
+	return *v
+}
+
+func intOr(v *int, def int) int {
+	if v == nil || *v == 0 {
+		return def
+	}
+	return *v
+}
+
+func boolOr(v *bool, def bool) bool {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+func int64Or(v *int64, def int64) int64 {
+	if v == nil || *v == 0 {
+		return def
+	}
+	return *v
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Node is a remote host running `wireguard-ui agent` for the interfaces assigned to it. The agent
+// authenticates with a token of which only the hash is stored; the rest is what it last reported.
+type Node struct {
+	UUID       string     `gorm:"type:uuid;primaryKey" json:"UUID"`
+	Name       string     `gorm:"not null" json:"Name"`
+	TokenHash  string     `gorm:"not null" json:"-"`
+	CreatedAt  time.Time  `gorm:"not null" json:"CreatedAt"`
+	LastSeenAt *time.Time `json:"LastSeenAt"`
+	// Address is where the agent last connected from
+	Address  *string `json:"Address"`
+	Hostname *string `json:"Hostname"`
+	Version  *string `json:"Version"`
+	// The agent's WG_CONF_DIR, WG_EXTERNAL_IF and WG_MODE, used to render its configs
+	ConfDir    *string `json:"ConfDir"`
+	ExternalIF *string `json:"ExternalIF"`
+	WGMode     *string `json:"WGMode"`
+	// LastError is the first error the agent hit applying its interfaces, nil when all went well
+	LastError *string `json:"LastError"`
+}
+
+func (Node) TableName() string { return "node" }
+
+// HashNodeToken returns the stored form of an agent token.
+func HashNodeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
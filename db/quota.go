@@ -0,0 +1,50 @@
+package db
+
+import "time"
+
+// Quota directions: which traffic counts against the limit, from the server's point of view.
+const (
+	QuotaRx    = "rx"
+	QuotaTx    = "tx"
+	QuotaTotal = "total"
+)
+
+// Quota periods; usage is counted from the start of the current period in UTC.
+const (
+	QuotaDay   = "day"
+	QuotaWeek  = "week" // weeks start on Monday
+	QuotaMonth = "month"
+	QuotaNever = "never" // lifetime quota, limited by the daily usage retention
+)
+
+// QuotaStatus is the state of a peer's effective quota. It is computed, not stored.
+type QuotaStatus struct {
+	LimitBytes  int64      `json:"LimitBytes"`
+	UsedBytes   int64      `json:"UsedBytes"`
+	Direction   string     `json:"Direction"`
+	Period      string     `json:"Period"`
+	PeriodStart time.Time  `json:"PeriodStart"`
+	ResetsAt    *time.Time `json:"ResetsAt"`
+	Exceeded    bool       `json:"Exceeded"`
+}
+
+// QuotaPeriodStart returns the start of the period containing now, and the start of the next one
+// (nil for lifetime quotas).
+func QuotaPeriodStart(period string, now time.Time) (time.Time, *time.Time) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var start, next time.Time
+	switch period {
+	case QuotaDay:
+		start, next = day, day.AddDate(0, 0, 1)
+	case QuotaWeek:
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		next = start.AddDate(0, 0, 7)
+	case QuotaMonth:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 1, 0)
+	default:
+		return time.Time{}, nil
+	}
+	return start, &next
+}
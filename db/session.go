@@ -0,0 +1,20 @@
+package db
+
+import "time"
+
+// PeerSession is one stretch of time a peer stayed connected from one endpoint. EndedAt is nil while
+// the session is open; otherwise it is the last handshake of the session. Sessions outlive their peer.
+type PeerSession struct {
+	ID              int64      `gorm:"primaryKey" json:"ID"`
+	PeerUUID        string     `gorm:"type:uuid;not null" json:"PeerUUID"`
+	PeerName        *string    `gorm:"->" json:"PeerName"`
+	Interface       string     `gorm:"not null" json:"Interface"`
+	Endpoint        string     `gorm:"not null" json:"Endpoint"`
+	StartedAt       time.Time  `gorm:"not null" json:"StartedAt"`
+	LastHandshakeAt time.Time  `gorm:"not null" json:"LastHandshakeAt"`
+	EndedAt         *time.Time `json:"EndedAt"`
+	RxBytes         int64      `gorm:"not null" json:"RxBytes"`
+	TxBytes         int64      `gorm:"not null" json:"TxBytes"`
+}
+
+func (PeerSession) TableName() string { return "peer_session" }
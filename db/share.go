@@ -0,0 +1,16 @@
+package db
+
+import "time"
+
+// ShareLink lets an unauthenticated holder of a signed token download one peer's config a limited number of times.
+type ShareLink struct {
+	UUID      string     `gorm:"type:uuid;primaryKey" json:"UUID"`
+	PeerUUID  string     `gorm:"type:uuid;not null" json:"PeerUUID"`
+	CreatedAt time.Time  `gorm:"not null" json:"CreatedAt"`
+	ExpiresAt time.Time  `gorm:"not null" json:"ExpiresAt"`
+	MaxUses   int        `gorm:"not null" json:"MaxUses"`
+	Uses      int        `gorm:"not null" json:"Uses"`
+	RevokedAt *time.Time `json:"RevokedAt"`
+}
+
+func (ShareLink) TableName() string { return "share_link" }
@@ -0,0 +1,35 @@
+package db
+
+import "time"
+
+// PeerCounter is the last transfer counter seen for a peer on the interface. Keeping it in the database
+// lets the collector compute deltas across restarts of this service.
+type PeerCounter struct {
+	PeerUUID  string    `gorm:"type:uuid;primaryKey" json:"PeerUUID"`
+	PublicKey string    `gorm:"not null" json:"-"`
+	RxBytes   int64     `gorm:"not null" json:"RxBytes"`
+	TxBytes   int64     `gorm:"not null" json:"TxBytes"`
+	SampledAt time.Time `gorm:"not null" json:"SampledAt"`
+}
+
+func (PeerCounter) TableName() string { return "peer_counter" }
+
+// Usage resolutions; each sample is added to one row of every resolution.
+const (
+	UsageRaw  = "raw"
+	UsageHour = "hour"
+	UsageDay  = "day"
+)
+
+// PeerUsage is the traffic of one peer within a bucket, from the server's point of view:
+// RxBytes were received from the peer (its upload), TxBytes sent to it (its download).
+type PeerUsage struct {
+	Resolution string    `gorm:"primaryKey" json:"Resolution"`
+	PeerUUID   string    `gorm:"type:uuid;primaryKey" json:"PeerUUID"`
+	Bucket     time.Time `gorm:"primaryKey" json:"Bucket"`
+	Interface  string    `gorm:"not null" json:"Interface"`
+	RxBytes    int64     `gorm:"not null" json:"RxBytes"`
+	TxBytes    int64     `gorm:"not null" json:"TxBytes"`
+}
+
+func (PeerUsage) TableName() string { return "peer_usage" }
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an outbound subscription to the event stream. Events empty means every event.
+type Webhook struct {
+	UUID      string     `gorm:"type:uuid;primaryKey" json:"UUID"`
+	Name      string     `gorm:"not null" json:"Name"`
+	URL       string     `gorm:"not null" json:"URL"`
+	Events    StringList `gorm:"type:jsonb;not null" json:"Events"`
+	Secret    string     `gorm:"not null" json:"-"`
+	Enabled   bool       `gorm:"not null" json:"Enabled"`
+	CreatedAt time.Time  `gorm:"not null" json:"CreatedAt"`
+}
+
+func (Webhook) TableName() string { return "webhook" }
+
+// WebhookDelivery is one event sent (or being retried) to one webhook.
+type WebhookDelivery struct {
+	ID            int64           `gorm:"primaryKey" json:"ID"`
+	WebhookUUID   string          `gorm:"type:uuid;not null" json:"WebhookUUID"`
+	EventID       int64           `gorm:"not null" json:"EventID"`
+	EventType     string          `gorm:"not null" json:"EventType"`
+	Payload       json.RawMessage `gorm:"type:jsonb;not null" json:"Payload"`
+	CreatedAt     time.Time       `gorm:"not null" json:"CreatedAt"`
+	Status        string          `gorm:"not null" json:"Status"`
+	Attempts      int             `gorm:"not null" json:"Attempts"`
+	NextAttemptAt *time.Time      `json:"NextAttemptAt"`
+	ResponseCode  *int            `json:"ResponseCode"`
+	Error         *string         `json:"Error"`
+	DeliveredAt   *time.Time      `json:"DeliveredAt"`
+}
+
+func (WebhookDelivery) TableName() string { return "webhook_delivery" }
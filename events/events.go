@@ -0,0 +1,153 @@
+// Package events is the in-process bus for state changes of peers, the interface and its configuration.
+// Publishers never block: a subscriber that falls behind is dropped and has to reconnect.
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	PeerCreated   = "peer.created"
+	PeerUpdated   = "peer.updated"
+	PeerDeleted   = "peer.deleted"
+	PeerHandshake = "peer.handshake"
+	PeerOffline   = "peer.offline"
+	PeerExpired   = "peer.expired"
+	PeerSuspended = "peer.suspended"
+	PeerResumed   = "peer.resumed"
+	InterfaceUp   = "interface.up"
+	InterfaceDown = "interface.down"
+	ConfigWritten = "config.written"
+	ConfigApplied = "config.applied"
+	AlertFiring   = "alert.firing"
+	AlertResolved = "alert.resolved"
+)
+
+// Types lists every event type, for validating subscription filters.
+var Types = []string{
+	PeerCreated, PeerUpdated, PeerDeleted, PeerHandshake, PeerOffline, PeerExpired, PeerSuspended, PeerResumed,
+	InterfaceUp, InterfaceDown, ConfigWritten, ConfigApplied, AlertFiring, AlertResolved,
+}
+
+// Event is one state change. ID increases by one per event for the lifetime of the process.
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Interface string    `json:"interface,omitempty"`
+	PeerUUID  string    `json:"peer_uuid,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Data      any       `json:"data,omitempty"`
+}
+
+const (
+	// historySize is how many recent events are kept for subscribers that resume after a disconnect
+	historySize = 256
+	// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+	subscriberBuffer = 64
+)
+
+type subscriber struct {
+	ch     chan Event
+	filter []string
+}
+
+var bus struct {
+	sync.Mutex
+	lastID  int64
+	history []Event
+	subs    map[*subscriber]struct{}
+}
+
+// Publish stamps the event with the next ID and the current time (unless set) and delivers it to every subscriber.
+func Publish(ev Event) {
+	bus.Lock()
+	defer bus.Unlock()
+	bus.lastID++
+	ev.ID = bus.lastID
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	bus.history = append(bus.history, ev)
+	if len(bus.history) > historySize {
+		bus.history = bus.history[len(bus.history)-historySize:]
+	}
+	for s := range bus.subs {
+		if !s.matches(ev.Type) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			delete(bus.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of events whose type matches one of types ("peer.created", or a whole
+// family with "peer.*"); no types means all events. Events after afterID that are still in the history
+// are delivered first, so a client resuming with its last seen ID misses nothing recent.
+// The channel is closed when the subscriber falls behind; cancel must be called when done.
+func Subscribe(types []string, afterID int64) (<-chan Event, func()) {
+	s := &subscriber{filter: types}
+	bus.Lock()
+	var backlog []Event
+	if afterID > 0 {
+		for _, ev := range bus.history {
+			if ev.ID > afterID && s.matches(ev.Type) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	s.ch = make(chan Event, subscriberBuffer+len(backlog))
+	for _, ev := range backlog {
+		s.ch <- ev
+	}
+	if bus.subs == nil {
+		bus.subs = map[*subscriber]struct{}{}
+	}
+	bus.subs[s] = struct{}{}
+	bus.Unlock()
+
+	cancel := func() {
+		bus.Lock()
+		defer bus.Unlock()
+		if _, ok := bus.subs[s]; ok {
+			delete(bus.subs, s)
+			close(s.ch)
+		}
+	}
+	return s.ch, cancel
+}
+
+func (s *subscriber) matches(typ string) bool {
+	return Match(s.filter, typ)
+}
+
+// Match reports whether typ is selected by filter: an exact type or a family such as "peer.*".
+// An empty filter selects everything.
+func Match(filter []string, typ string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == typ || (strings.HasSuffix(f, ".*") && strings.HasPrefix(typ, strings.TrimSuffix(f, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidFilter reports whether f names an event type or a family of them.
+func ValidFilter(f string) bool {
+	for _, t := range Types {
+		if Match([]string{f}, t) {
+			return true
+		}
+	}
+	return false
+}
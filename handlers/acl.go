@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// reloadFirewall regenerates the server configs and ACL scripts and reloads the chains on the running
+// interfaces as WG_APPLY_MODE asks. A failed write or live reload is reported in the result.
+func reloadFirewall(cfg *config.Config) wireguard.ApplyResult {
+	if err := wireguard.WriteAllConfigs(cfg); err != nil {
+		return wireguard.ApplyResult{Error: err.Error()}
+	}
+	return wireguard.ApplyAllChanges(cfg, wireguard.Reload{ACL: true})
+}
+
+// GET /api/v1/acl -> List ACL rules (?peer=<uuid> or ?group=<uuid>), in evaluation order
+func GetACLRules(c *gin.Context) {
+	q := db.DB.Order("position, created_at")
+	if v := c.Query("peer"); v != "" {
+		q = q.Where("peer_uuid = ?", v)
+	}
+	if v := c.Query("group"); v != "" {
+		q = q.Where("group_uuid = ?", v)
+	}
+	var rules []db.ACLRule
+	if err := q.Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query acl rules failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// POST /api/v1/acl -> Create ACL rule for a peer or a group
+// PUT /api/v1/acl/:uuid -> Update ACL rule (any subset of fields; the owner cannot change)
+type ACLRuleRequest struct {
+	PeerUUID    *string `json:"peer_uuid"`
+	GroupUUID   *string `json:"group_uuid"`
+	Position    *int    `json:"position"`
+	Action      *string `json:"action"`
+	Destination *string `json:"destination"` // CIDR or bare address; empty means anywhere
+	Protocol    *string `json:"protocol"`    // any, tcp, udp or icmp
+	Ports       *string `json:"ports"`       // e.g. "22,80,8000-8100"; tcp and udp only
+	Description *string `json:"description"`
+}
+
+// apply validates the request and merges it into r.
+func (req ACLRuleRequest) apply(r *db.ACLRule) error {
+	if req.Position != nil {
+		r.Position = *req.Position
+	}
+	if req.Action != nil {
+		switch a := strings.ToLower(*req.Action); a {
+		case db.ACLAccept, db.ACLDrop:
+			r.Action = a
+		default:
+			return errors.New("action must be accept or drop")
+		}
+	}
+	if req.Destination != nil {
+		d := strings.TrimSpace(*req.Destination)
+		switch {
+		case d == "":
+			r.Destination = nil
+		default:
+			prefix, err := parseCIDROrAddr(d)
+			if err != nil {
+				return fmt.Errorf("invalid destination: %v", err)
+			}
+			v := prefix.Masked().String()
+			r.Destination = &v
+		}
+	}
+	if req.Protocol != nil {
+		switch p := strings.ToLower(*req.Protocol); p {
+		case "", "any":
+			r.Protocol = "any"
+		case "tcp", "udp", "icmp":
+			r.Protocol = p
+		default:
+			return errors.New("protocol must be any, tcp, udp or icmp")
+		}
+	}
+	if req.Ports != nil {
+		ports, err := normalizePorts(*req.Ports)
+		if err != nil {
+			return err
+		}
+		r.Ports = ports
+	}
+	if r.Ports != nil && r.Protocol != "tcp" && r.Protocol != "udp" {
+		return errors.New("ports require protocol tcp or udp")
+	}
+	if req.Description != nil {
+		if d := strings.TrimSpace(*req.Description); d == "" {
+			r.Description = nil
+		} else {
+			r.Description = &d
+		}
+	}
+	return nil
+}
+
+func parseCIDROrAddr(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// normalizePorts validates a port list for iptables multiport, which takes at most 15 ports (a range counts as two).
+func normalizePorts(raw string) (*string, error) {
+	var items []string
+	slots := 0
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(strings.ReplaceAll(item, ":", "-"), "-")
+		from, err := strconv.Atoi(lo)
+		if err != nil || from < 1 || from > 65535 {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		if !isRange {
+			items = append(items, strconv.Itoa(from))
+			slots++
+			continue
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil || to < from || to > 65535 {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+		items = append(items, fmt.Sprintf("%d-%d", from, to))
+		slots += 2
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	if slots > 15 {
+		return nil, errors.New("too many ports: at most 15, with a range counting as two")
+	}
+	v := strings.Join(items, ",")
+	return &v, nil
+}
+
+func CreateACLRule(c *gin.Context) {
+	var req ACLRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Action == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	r := db.ACLRule{Protocol: "any"}
+	switch {
+	case req.PeerUUID != nil && req.GroupUUID == nil:
+		var p db.Peer
+		if err := db.DB.Where("uuid = ?", *req.PeerUUID).First(&p).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "peer not found"})
+			return
+		}
+		r.PeerUUID = &p.UUID
+	case req.GroupUUID != nil && req.PeerUUID == nil:
+		var g db.PeerGroup
+		if err := db.DB.Where("uuid = ?", *req.GroupUUID).First(&g).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "group not found"})
+			return
+		}
+		r.GroupUUID = &g.UUID
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of peer_uuid and group_uuid is required"})
+		return
+	}
+	if err := req.apply(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create acl rule failed: %v", err)})
+		return
+	}
+	applied := reloadFirewall(config.LoadConfig())
+	c.JSON(http.StatusOK, withApply(gin.H{"rule": r}, applied))
+}
+
+func UpdateACLRule(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var r db.ACLRule
+	if err := db.DB.Where("uuid = ?", uuid).First(&r).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "acl rule not found"})
+		return
+	}
+	var req ACLRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.PeerUUID != nil || req.GroupUUID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the owner of a rule cannot change; delete and recreate it"})
+		return
+	}
+	if err := req.apply(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := db.DB.Model(&db.ACLRule{}).Where("uuid = ?", uuid).Updates(map[string]any{
+		"position":    r.Position,
+		"action":      r.Action,
+		"destination": r.Destination,
+		"protocol":    r.Protocol,
+		"ports":       r.Ports,
+		"description": r.Description,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update acl rule failed: %v", err)})
+		return
+	}
+	applied := reloadFirewall(config.LoadConfig())
+	c.JSON(http.StatusOK, withApply(gin.H{"rule": r}, applied))
+}
+
+// DELETE /api/v1/acl/:uuid -> Delete ACL rule
+func DeleteACLRule(c *gin.Context) {
+	res := db.DB.Delete(&db.ACLRule{}, "uuid = ?", c.Param("uuid"))
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete acl rule failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "acl rule not found"})
+		return
+	}
+	applied := reloadFirewall(config.LoadConfig())
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "acl rule deleted"}, applied))
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/alerts"
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GET /api/v1/alerts -> Alerts, newest first (?state=firing|resolved, ?rule=, ?peer=, ?limit=50, ?offset=0)
+func ListAlerts(c *gin.Context) {
+	q := db.DB.Model(&db.Alert{}).
+		Select("alert.*, peer.name AS peer_name, alert_rule.name AS rule_name").
+		Joins("LEFT JOIN peer ON peer.uuid = alert.peer_uuid").
+		Joins("LEFT JOIN alert_rule ON alert_rule.uuid = alert.rule_uuid")
+	switch state := c.Query("state"); state {
+	case "":
+	case db.AlertFiring, db.AlertResolved:
+		q = q.Where("alert.state = ?", state)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be firing or resolved"})
+		return
+	}
+	if v := c.Query("rule"); v != "" {
+		q = q.Where("alert.rule_uuid = ?", v)
+	}
+	if v := c.Query("peer"); v != "" {
+		q = q.Where("alert.peer_uuid = ?", v)
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+	var total int64
+	if err := q.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count alerts failed: %v", err)})
+		return
+	}
+	list := []db.Alert{}
+	if err := q.Order("alert.fired_at DESC, alert.id DESC").Limit(limit).Offset(offset).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query alerts failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": list, "total": total, "limit": limit, "offset": offset})
+}
+
+// GET /api/v1/alerts/rules -> List alert rules
+func GetAlertRules(c *gin.Context) {
+	var rules []db.AlertRule
+	if err := db.DB.Order("created_at").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query alert rules failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// POST /api/v1/alerts/rules -> Create alert rule
+// PUT /api/v1/alerts/rules/:uuid -> Update alert rule (any subset of fields)
+type AlertRuleRequest struct {
+	Name *string `json:"name"`
+	// At most one of peer_uuid and group_uuid; neither covers every peer. An empty string clears it.
+	PeerUUID  *string `json:"peer_uuid"`
+	GroupUUID *string `json:"group_uuid"`
+	Kind      *string `json:"kind"`
+	// Minutes is the staleness for stale_handshake, how long endpoint_change fires, and the window of traffic_spike
+	Minutes *int `json:"minutes"`
+	// Bytes is the traffic_spike threshold
+	Bytes *int64 `json:"bytes"`
+	// Notifiers are notifier uuids; empty sends to every enabled notifier
+	Notifiers *[]string `json:"notifiers"`
+	Enabled   *bool     `json:"enabled"`
+}
+
+// apply validates the request and merges it into r.
+func (req AlertRuleRequest) apply(r *db.AlertRule) error {
+	if req.Name != nil {
+		r.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.PeerUUID != nil {
+		if *req.PeerUUID == "" {
+			r.PeerUUID = nil
+		} else {
+			var p db.Peer
+			if err := db.DB.Select("uuid").Where("uuid = ?", *req.PeerUUID).First(&p).Error; err != nil {
+				return errors.New("peer not found")
+			}
+			r.PeerUUID = &p.UUID
+		}
+	}
+	if req.GroupUUID != nil {
+		if *req.GroupUUID == "" {
+			r.GroupUUID = nil
+		} else {
+			var g db.PeerGroup
+			if err := db.DB.Select("uuid").Where("uuid = ?", *req.GroupUUID).First(&g).Error; err != nil {
+				return errors.New("group not found")
+			}
+			r.GroupUUID = &g.UUID
+		}
+	}
+	if r.PeerUUID != nil && r.GroupUUID != nil {
+		return errors.New("at most one of peer_uuid and group_uuid may be set")
+	}
+	if req.Kind != nil {
+		switch k := *req.Kind; k {
+		case db.AlertStaleHandshake, db.AlertEndpointChange, db.AlertTrafficSpike:
+			r.Kind = k
+		default:
+			return errors.New("kind must be stale_handshake, endpoint_change or traffic_spike")
+		}
+	}
+	if req.Minutes != nil {
+		if *req.Minutes < 1 {
+			return errors.New("minutes must be at least 1")
+		}
+		r.Minutes = *req.Minutes
+	}
+	if req.Bytes != nil {
+		if *req.Bytes < 1 {
+			return errors.New("bytes must be at least 1")
+		}
+		r.Bytes = req.Bytes
+	}
+	if r.Kind == db.AlertTrafficSpike && r.Bytes == nil {
+		return errors.New("bytes is required for traffic_spike")
+	}
+	if req.Notifiers != nil {
+		list := db.StringList{}
+		for _, uuid := range *req.Notifiers {
+			var count int64
+			if err := db.DB.Model(&db.AlertNotifier{}).Where("uuid = ?", uuid).Count(&count).Error; err != nil || count == 0 {
+				return fmt.Errorf("notifier %s not found", uuid)
+			}
+			list = append(list, uuid)
+		}
+		r.Notifiers = list
+	}
+	if req.Enabled != nil {
+		r.Enabled = *req.Enabled
+	}
+	if r.Name == "" {
+		r.Name = r.Kind
+	}
+	return nil
+}
+
+func CreateAlertRule(c *gin.Context) {
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Kind == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	r := db.AlertRule{Minutes: 60, Notifiers: db.StringList{}, Enabled: true, CreatedAt: time.Now()}
+	if *req.Kind == db.AlertStaleHandshake {
+		r.Minutes = 10
+	}
+	if err := req.apply(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create alert rule failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": r})
+}
+
+func UpdateAlertRule(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var r db.AlertRule
+	if err := db.DB.Where("uuid = ?", uuid).First(&r).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := req.apply(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := db.DB.Model(&db.AlertRule{}).Where("uuid = ?", uuid).Updates(map[string]any{
+		"name":       r.Name,
+		"peer_uuid":  r.PeerUUID,
+		"group_uuid": r.GroupUUID,
+		"kind":       r.Kind,
+		"minutes":    r.Minutes,
+		"bytes":      r.Bytes,
+		"notifiers":  r.Notifiers,
+		"enabled":    r.Enabled,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update alert rule failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": r})
+}
+
+// DELETE /api/v1/alerts/rules/:uuid -> Delete alert rule and its alerts
+func DeleteAlertRule(c *gin.Context) {
+	res := db.DB.Delete(&db.AlertRule{}, "uuid = ?", c.Param("uuid"))
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete alert rule failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "alert rule deleted"})
+}
+
+// GET /api/v1/alerts/notifiers -> List notifiers (secrets are never returned)
+func GetAlertNotifiers(c *gin.Context) {
+	var notifiers []db.AlertNotifier
+	if err := db.DB.Order("created_at").Find(&notifiers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query notifiers failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifiers": notifiers})
+}
+
+// POST /api/v1/alerts/notifiers -> Create notifier
+// PUT /api/v1/alerts/notifiers/:uuid -> Update notifier (any subset of fields)
+type AlertNotifierRequest struct {
+	Name *string `json:"name"`
+	Kind *string `json:"kind"` // webhook, chat or email
+	// Target is the URL for webhook and chat notifiers, the address for email
+	Target *string `json:"target"`
+	// Secret signs webhook notifications; empty removes it
+	Secret  *string `json:"secret"`
+	Enabled *bool   `json:"enabled"`
+}
+
+// apply validates the request and merges it into n.
+func (req AlertNotifierRequest) apply(n *db.AlertNotifier) error {
+	if req.Name != nil {
+		n.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Kind != nil {
+		switch k := *req.Kind; k {
+		case db.NotifierWebhook, db.NotifierChat, db.NotifierEmail:
+			n.Kind = k
+		default:
+			return errors.New("kind must be webhook, chat or email")
+		}
+	}
+	if req.Target != nil {
+		n.Target = strings.TrimSpace(*req.Target)
+	}
+	if n.Kind == db.NotifierEmail {
+		addr, err := normalizeEmail(n.Target)
+		if err != nil || addr == nil {
+			return errors.New("target must be an email address")
+		}
+		n.Target = *addr
+	} else {
+		u, err := parseHookURL(n.Target)
+		if err != nil {
+			return errors.New("target must be an absolute http or https URL")
+		}
+		n.Target = u
+	}
+	if req.Secret != nil {
+		if s := strings.TrimSpace(*req.Secret); s == "" {
+			n.Secret = nil
+		} else {
+			n.Secret = &s
+		}
+	}
+	if req.Enabled != nil {
+		n.Enabled = *req.Enabled
+	}
+	if n.Name == "" {
+		n.Name = n.Target
+	}
+	return nil
+}
+
+func CreateAlertNotifier(c *gin.Context) {
+	var req AlertNotifierRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Kind == nil || req.Target == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	n := db.AlertNotifier{Enabled: true, CreatedAt: time.Now()}
+	if err := req.apply(&n); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&n).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create notifier failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifier": n})
+}
+
+func UpdateAlertNotifier(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var n db.AlertNotifier
+	if err := db.DB.Where("uuid = ?", uuid).First(&n).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notifier not found"})
+		return
+	}
+	var req AlertNotifierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := req.apply(&n); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := db.DB.Model(&db.AlertNotifier{}).Where("uuid = ?", uuid).Updates(map[string]any{
+		"name":    n.Name,
+		"kind":    n.Kind,
+		"target":  n.Target,
+		"secret":  n.Secret,
+		"enabled": n.Enabled,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update notifier failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifier": n})
+}
+
+// DELETE /api/v1/alerts/notifiers/:uuid -> Delete notifier and remove it from the rules that named it
+func DeleteAlertNotifier(c *gin.Context) {
+	uuid := c.Param("uuid")
+	res := db.DB.Delete(&db.AlertNotifier{}, "uuid = ?", uuid)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete notifier failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notifier not found"})
+		return
+	}
+	// A rule left with no notifiers sends to all of them
+	_ = db.DB.Model(&db.AlertRule{}).Where("notifiers @> ?::jsonb", db.StringList{uuid}).
+		Update("notifiers", gorm.Expr("notifiers - ?", uuid)).Error
+	c.JSON(http.StatusOK, gin.H{"message": "notifier deleted"})
+}
+
+// POST /api/v1/alerts/notifiers/:uuid/test -> Send a test alert through the notifier
+func TestAlertNotifier(c *gin.Context) {
+	var n db.AlertNotifier
+	if err := db.DB.Where("uuid = ?", c.Param("uuid")).First(&n).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notifier not found"})
+		return
+	}
+	name := "test"
+	a := db.Alert{Kind: "test", State: db.AlertFiring, Detail: "test notification from wireguard-ui", FiredAt: time.Now(), RuleName: &name, PeerName: &name}
+	if err := alerts.Notify(config.LoadConfig(), n, a); err != nil {
+		c.JSON(http.StatusOK, gin.H{"sent": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sent": true})
+}
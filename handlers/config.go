@@ -1,36 +1,56 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/StellaShiina/wireguard-ui/config"
 	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/netutil"
+	"github.com/StellaShiina/wireguard-ui/qr"
+	"github.com/StellaShiina/wireguard-ui/stats"
 	"github.com/StellaShiina/wireguard-ui/wireguard"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-// GET /api/v1/configs -> server + peers
+// GET /api/v1/configs -> server + peers (optionally only the members of ?group=<uuid>)
 func GetConfigs(c *gin.Context) {
-	var s db.Server
-	if err := db.DB.Limit(1).Find(&s).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query server failed: %v", err)})
-		return
-	}
-	if s.UUID == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "server not initialized"})
+	_, s, ok := interfaceOf(c)
+	if !ok {
 		return
 	}
 
+	q := db.DB.Scopes(db.OnServer(s.UUID)).Preload("Group")
+	if group := c.Query("group"); group != "" {
+		if !validUUIDs(group) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group uuid"})
+			return
+		}
+		q = q.Where("group_uuid = ?", group)
+	}
 	var peers []db.Peer
-	if err := db.DB.Find(&peers).Error; err != nil {
+	if err := q.Find(&peers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
 		return
 	}
 
+	if err := stats.AttachQuotas(peers, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query quotas failed: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"server": s, "peers": peers})
 }
 
@@ -41,15 +61,91 @@ type UpdateServerRequest struct {
 	EnableIPv6 *bool   `json:"enable_ipv6"`
 	SubnetV4   *string `json:"subnet_v4"`
 	SubnetV6   *string `json:"subnet_v6"`
+	// ResetPeers deletes every peer and client file on a subnet change instead of renumbering them
+	ResetPeers bool `json:"reset_peers"`
+}
+
+// RenumberedPeer is a peer whose addresses moved with a subnet change; its client needs the new config.
+type RenumberedPeer struct {
+	UUID    string  `json:"uuid"`
+	Name    *string `json:"name"`
+	OldIPv4 *string `json:"old_ipv4"`
+	IPv4    *string `json:"ipv4"`
+	OldIPv6 *string `json:"old_ipv6"`
+	IPv6    *string `json:"ipv6"`
+}
+
+// planRenumber maps the peers into the new subnets (nil for a family that keeps its subnet) and returns
+// those whose addresses change.
+func planRenumber(s db.Server, peers []db.Peer, subnetV4, subnetV6 *netip.Prefix) ([]RenumberedPeer, error) {
+	plan := make([]RenumberedPeer, len(peers))
+	for i, p := range peers {
+		plan[i] = RenumberedPeer{UUID: p.UUID, Name: p.Name, OldIPv4: p.IPv4, IPv4: p.IPv4, OldIPv6: p.IPv6, IPv6: p.IPv6}
+	}
+	for _, fam := range []struct {
+		old  string
+		to   *netip.Prefix
+		addr func(*RenumberedPeer) **string
+	}{
+		{s.SubnetV4, subnetV4, func(r *RenumberedPeer) **string { return &r.IPv4 }},
+		{s.SubnetV6, subnetV6, func(r *RenumberedPeer) **string { return &r.IPv6 }},
+	} {
+		if fam.to == nil {
+			continue
+		}
+		// An unparsable old subnet leaves the zero prefix, which contains nothing: every peer gets the next free address
+		from, _ := netip.ParsePrefix(fam.old)
+		addrs := make([]string, len(peers))
+		for i := range plan {
+			addrs[i] = valOrEmpty(*fam.addr(&plan[i]))
+		}
+		moved, err := netutil.Renumber(from, *fam.to, addrs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range plan {
+			*fam.addr(&plan[i]) = &moved[i]
+		}
+	}
+	var changed []RenumberedPeer
+	for _, r := range plan {
+		if valOrEmpty(r.IPv4) != valOrEmpty(r.OldIPv4) || valOrEmpty(r.IPv6) != valOrEmpty(r.OldIPv6) {
+			changed = append(changed, r)
+		}
+	}
+	return changed, nil
+}
+
+// applyRenumber moves peers to their new addresses. A new address may still belong to another peer of the
+// plan, so the moved peers are cleared first; peer_update_guard only allows this with wgui.renumber set.
+func applyRenumber(tx *gorm.DB, plan []RenumberedPeer) error {
+	if len(plan) == 0 {
+		return nil
+	}
+	if err := tx.Exec("SET LOCAL wgui.renumber = 'on'").Error; err != nil {
+		return err
+	}
+	uuids := make([]string, len(plan))
+	for i, r := range plan {
+		uuids[i] = r.UUID
+	}
+	if err := tx.Model(&db.Peer{}).Where("uuid IN ?", uuids).Updates(map[string]any{"ipv4": nil, "ipv6": nil}).Error; err != nil {
+		return err
+	}
+	for _, r := range plan {
+		if err := tx.Model(&db.Peer{}).Where("uuid = ?", r.UUID).Updates(map[string]any{"ipv4": r.IPv4, "ipv6": r.IPv6}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func UpdateServer(c *gin.Context) {
-	uuid := c.Param("uuid")
-	var s db.Server
-	if err := db.DB.Where("uuid = ?", uuid).First(&s).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+	cfg, s, ok := serverOf(c)
+	if !ok {
 		return
 	}
+	uuid := s.UUID
 	var req UpdateServerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
@@ -65,67 +161,150 @@ func UpdateServer(c *gin.Context) {
 	if req.EnableIPv6 != nil {
 		updates["enable_ipv6"] = *req.EnableIPv6
 	}
+	// New subnets, only set when they differ from the current ones
+	var subnetV4, subnetV6 *netip.Prefix
 	if req.SubnetV4 != nil {
-		updates["subnet_v4"] = *req.SubnetV4
+		p, err := netutil.ParseSubnet("ipv4", *req.SubnetV4)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["subnet_v4"] = p.String()
+		if p.String() != s.SubnetV4 {
+			subnetV4 = &p
+		}
 	}
 	if req.SubnetV6 != nil {
-		updates["subnet_v6"] = *req.SubnetV6
+		p, err := netutil.ParseSubnet("ipv6", *req.SubnetV6)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["subnet_v6"] = p.String()
+		if p.String() != s.SubnetV6 {
+			subnetV6 = &p
+		}
 	}
 	if len(updates) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
 		return
 	}
-	if err := db.DB.Model(&db.Server{}).Where("uuid = ?", uuid).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update server failed: %v", err)})
+	subnetChanged := subnetV4 != nil || subnetV6 != nil
+	if err := checkSubnets(uuid, subnetV4, subnetV6); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
-	// If the subnet changes, clear all peers (and delete their client configuration files)
-	subnetChanged := (req.SubnetV4 != nil && *req.SubnetV4 != s.SubnetV4) || (req.SubnetV6 != nil && *req.SubnetV6 != s.SubnetV6)
-	if subnetChanged {
-		// Delete all peers in the database
-		if err := db.DB.Delete(&db.Peer{}, "1=1").Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("clear peers failed: %v", err)})
+	if req.Port != nil && *req.Port != s.Port {
+		var taken int64
+		_ = db.DB.Model(&db.Server{}).Where("port = ? AND uuid <> ? AND node_uuid IS NOT DISTINCT FROM ?", *req.Port, uuid, s.NodeUUID).Count(&taken).Error
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("port %d is used by another interface on the same host", *req.Port)})
 			return
 		}
-		// Delete client configuration files
-		cfg := config.LoadConfig()
-		entries, _ := os.ReadDir(cfg.WGClientsDir)
-		for _, e := range entries {
-			// best-effort
-			_ = os.Remove(filepath.Join(cfg.WGClientsDir, e.Name()))
+	}
+
+	// Peers keep their offset in the subnet where it fits, so work out the new addresses before changing anything
+	var plan []RenumberedPeer
+	if subnetChanged && !req.ResetPeers {
+		var peers []db.Peer
+		if err := db.DB.Scopes(db.OnServer(uuid)).Select("uuid", "name", "ipv4", "ipv6").Order("ipv4, created_at").Find(&peers).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
+			return
+		}
+		var err error
+		if plan, err = planRenumber(s, peers, subnetV4, subnetV6); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 	}
-	// After updating, automatically generate new Server public/private keys and write to the database
-	priv, pub, err := wireguard.GenerateKeyPair()
+	var deleted []db.Peer
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&db.Server{}).Where("uuid = ?", uuid).Updates(updates).Error; err != nil {
+			return err
+		}
+		if subnetChanged && req.ResetPeers {
+			return tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Scopes(db.OnServer(uuid)).Delete(&deleted).Error
+		}
+		return applyRenumber(tx, plan)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server key failed: %v", err)})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update server failed: %v", err)})
 		return
 	}
-	if err := db.DB.Model(&db.Server{}).Where("uuid = ?", uuid).Updates(map[string]any{"private_key": priv, "public_key": pub}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("save server key failed: %v", err)})
-		return
+	actor := c.GetString("username")
+	if subnetChanged && req.ResetPeers {
+		// Delete the client configuration files of this interface's peers
+		for _, p := range deleted {
+			// best-effort
+			_ = os.Remove(filepath.Join(cfg.WGClientsDir, fmt.Sprintf("%s.conf", p.UUID)))
+		}
+		_ = db.RecordAudit(actor, "server.reset_peers", "", fmt.Sprintf("%s: subnets %s, %s", s.Interface, updatesOr(updates, "subnet_v4", s.SubnetV4), updatesOr(updates, "subnet_v6", s.SubnetV6)))
+	} else if subnetChanged {
+		_ = db.RecordAudit(actor, "server.renumber", "", fmt.Sprintf("%s: subnets %s, %s -> %s, %s; %d peers renumbered",
+			s.Interface, s.SubnetV4, s.SubnetV6, updatesOr(updates, "subnet_v4", s.SubnetV4), updatesOr(updates, "subnet_v6", s.SubnetV6), len(plan)))
+		for _, r := range plan {
+			publishPeer(c, s.Interface, events.PeerUpdated, r.UUID, r.Name, map[string]any{"fields": []string{"ipv4", "ipv6"}})
+		}
 	}
-
-	// Regenerate server configuration and all client configurations
-	cfg := config.LoadConfig()
+	// Regenerate server configuration and all client configurations; the key pair only changes through rotate-keys
 	var s2 db.Server
 	_ = db.DB.Where("uuid = ?", uuid).First(&s2).Error
-	var peers []db.Peer
-	_ = db.DB.Find(&peers).Error
-	if err := wireguard.GenerateServerConfig(cfg, s2, peers); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server config failed: %v", err)})
+	if err := ensureServerKey(&s2); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server key failed: %v", err)})
 		return
 	}
-	for _, p := range peers {
-		_, _ = wireguard.GeneratePeerConfig(cfg, s2, p)
+	if err := wireguard.WriteInterfaceConfigs(cfg, s2); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate configs failed: %v", err)})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "server updated; regenerated keys and peer configs"})
+	// Port and peer changes load live; addresses only change with a restart
+	resp := withApply(gin.H{"message": "server updated; regenerated server and peer configs"}, wireguard.ApplyChanges(cfg, wireguard.Reload{Peers: true}))
+	if subnetChanged || req.EnableIPv6 != nil && *req.EnableIPv6 != s.EnableIPv6 {
+		resp["restart_required"] = !cfg.Remote() && wireguard.InterfaceUp(cfg)
+	}
+	if subnetChanged && !req.ResetPeers {
+		if plan == nil {
+			plan = []RenumberedPeer{}
+		}
+		resp["message"] = fmt.Sprintf("server updated; renumbered %d peers, regenerated server and peer configs", len(plan))
+		// The clients listed here must fetch their new config; the running interface keeps its old address until
+		// restarted, which a node agent does by itself when the address changes
+		resp["renumbered"] = plan
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// updatesOr returns the new value of a column in an update map, or the current one.
+func updatesOr(updates map[string]any, key, current string) string {
+	if v, ok := updates[key].(string); ok {
+		return v
+	}
+	return current
 }
 
 // POST /api/v1/configs/peer -> Add peer (automatically assign IPv4/IPv6)
 type CreatePeerRequest struct {
 	Name *string `json:"name"`
+	// PublicKey switches to bring-your-own-key mode: the client keeps its private key and the server stores none
+	PublicKey *string  `json:"public_key"`
+	GroupUUID *string  `json:"group_uuid"`
+	Tags      []string `json:"tags"`
+	Email     *string  `json:"email"`
+	// Rate limits in kbit/s; 0 means no limit
+	RateUpKbit   *int `json:"rate_up_kbit"`
+	RateDownKbit *int `json:"rate_down_kbit"`
+}
+
+// rateLimit validates a rate limit in kbit/s from a request; 0 means no limit and comes back as nil.
+func rateLimit(field string, kbit int) (*int, error) {
+	if kbit < 0 {
+		return nil, errors.New(field + " must not be negative")
+	}
+	if kbit == 0 {
+		return nil, nil
+	}
+	return &kbit, nil
 }
 
 func CreatePeer(c *gin.Context) {
@@ -134,107 +313,519 @@ func CreatePeer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
-	// Backend generates key pair, not returned in response
-	priv, pub, err := wireguard.GenerateKeyPair()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate peer key failed: %v", err)})
+	cfg, s, ok := interfaceOf(c)
+	if !ok {
 		return
 	}
-	p := db.Peer{PrivateKey: priv, PublicKey: pub, Name: req.Name}
-	if err = db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}, {Name: "ipv4"}, {Name: "ipv6"}, {Name: "name"}}}).Omit("uuid").Create(&p).Error; err != nil {
+	p := db.Peer{ServerUUID: s.UUID, Name: req.Name, Enabled: true, Tags: normalizeTags(req.Tags)}
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p.Email = email
+	}
+	if req.RateUpKbit != nil {
+		limit, err := rateLimit("rate_up_kbit", *req.RateUpKbit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p.RateUpKbit = limit
+	}
+	if req.RateDownKbit != nil {
+		limit, err := rateLimit("rate_down_kbit", *req.RateDownKbit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p.RateDownKbit = limit
+	}
+	var group *db.PeerGroup
+	if req.GroupUUID != nil && *req.GroupUUID != "" {
+		group = &db.PeerGroup{}
+		if err := db.DB.Where("uuid = ?", *req.GroupUUID).First(group).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "group not found"})
+			return
+		}
+		p.GroupUUID = &group.UUID
+		// Group expiry policy applies to new members
+		if group.ExpiryDays != nil && *group.ExpiryDays > 0 {
+			expires := time.Now().AddDate(0, 0, *group.ExpiryDays)
+			p.ExpiresAt = &expires
+		}
+	}
+	if req.PublicKey != nil {
+		if !wireguard.ValidKey(*req.PublicKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid public key"})
+			return
+		}
+		var count int64
+		if err := db.DB.Model(&db.Peer{}).Where("public_key = ?", *req.PublicKey).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "public key already in use"})
+			return
+		}
+		p.PublicKey = *req.PublicKey
+	} else {
+		// Backend generates key pair, not returned in response
+		priv, pub, err := wireguard.GenerateKeyPair()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate peer key failed: %v", err)})
+			return
+		}
+		p.PrivateKey = &priv
+		p.PublicKey = pub
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}, {Name: "ipv4"}, {Name: "ipv6"}, {Name: "name"}}}).Omit("uuid").Create(&p).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "public key already in use"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create peer failed: %v", err)})
 		return
 	}
+	p.Group = group
 
 	// Generate client configuration file and update server configuration
-	cfg := config.LoadConfig()
-	var s db.Server
-	_ = db.DB.Limit(1).Find(&s).Error
-	// If the server key is empty or non-standard (wg key length is about 44), generate and save it
-	if len(s.PrivateKey) < 40 || len(s.PublicKey) < 40 {
-		sPriv, sPub, err2 := wireguard.GenerateKeyPair()
-		if err2 == nil {
-			_ = db.DB.Model(&db.Server{}).Where("uuid = ?", s.UUID).Updates(map[string]any{"private_key": sPriv, "public_key": sPub}).Error
-			s.PrivateKey = sPriv
-			s.PublicKey = sPub
-		}
-	}
+	_ = ensureServerKey(&s)
 	path, err := wireguard.GeneratePeerConfig(cfg, s, p)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate peer config failed: %v", err)})
 		return
 	}
 	var peers []db.Peer
-	_ = db.DB.Find(&peers).Error
+	_ = db.DB.Scopes(db.OnServer(s.UUID)).Find(&peers).Error
 	_ = wireguard.GenerateServerConfig(cfg, s, peers)
+	// Group rules and isolation cover the new address, and its own rate limits apply from the start
+	applied := wireguard.ApplyChanges(cfg, wireguard.Reload{Peers: true, ACL: p.GroupUUID != nil, Shaping: p.RateUpKbit != nil || p.RateDownKbit != nil})
+	publishPeer(c, s.Interface, events.PeerCreated, p.UUID, p.Name, nil)
 
-	c.JSON(http.StatusOK, gin.H{"peer": p, "path": path})
+	c.JSON(http.StatusOK, withApply(gin.H{"peer": p, "path": path}, applied))
 }
 
-// PUT /api/v1/configs/peer/:uuid -> Update peer (name only)
+// PUT /api/v1/configs/peer/:uuid -> Update peer (name, enabled flag, group membership and client setting overrides)
 type UpdatePeerRequest struct {
-	Name *string `json:"name"`
+	Name    *string `json:"name"`
+	Enabled *bool   `json:"enabled"`
+	// GroupUUID moves the peer into a group; an empty string removes it from its group
+	GroupUUID *string `json:"group_uuid"`
+	// ExpiresAt is RFC 3339; an empty string removes the expiry
+	ExpiresAt *string `json:"expires_at"`
+	// Tags replaces the whole tag list
+	Tags *[]string `json:"tags"`
+	// Email is the config delivery address; an empty string removes it
+	Email *string `json:"email"`
+	// Rate limits in kbit/s; 0 removes the limit
+	RateUpKbit   *int `json:"rate_up_kbit"`
+	RateDownKbit *int `json:"rate_down_kbit"`
+	PeerSettingsRequest
 }
 
 func UpdatePeer(c *gin.Context) {
 	uuid := c.Param("uuid")
 	var req UpdatePeerRequest
-	if err := c.ShouldBindJSON(&req); err != nil || req.Name == nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
-	if err := db.DB.Model(&db.Peer{}).Where("uuid = ?", uuid).Update("name", *req.Name).Error; err != nil {
+	updates, err := req.PeerSettingsRequest.updates()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+	if req.Tags != nil {
+		updates["tags"] = normalizeTags(*req.Tags)
+	}
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["email"] = email
+	}
+	for col, v := range map[string]*int{"rate_up_kbit": req.RateUpKbit, "rate_down_kbit": req.RateDownKbit} {
+		if v == nil {
+			continue
+		}
+		limit, err := rateLimit(col, *v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if limit == nil {
+			updates[col] = nil
+		} else {
+			updates[col] = *limit
+		}
+	}
+	if req.ExpiresAt != nil {
+		if *req.ExpiresAt == "" {
+			updates["expires_at"] = nil
+		} else {
+			t, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be RFC 3339"})
+				return
+			}
+			updates["expires_at"] = t
+		}
+	}
+	var group *db.PeerGroup
+	if req.GroupUUID != nil {
+		if *req.GroupUUID == "" {
+			updates["group_uuid"] = nil
+		} else {
+			group = &db.PeerGroup{}
+			if err := db.DB.Where("uuid = ?", *req.GroupUUID).First(group).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "group not found"})
+				return
+			}
+			updates["group_uuid"] = group.UUID
+		}
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+	var cur db.Peer
+	if err := db.DB.Select("uuid", "server_uuid").Where("uuid = ?", uuid).First(&cur).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return
+	}
+	cfg, s, ok := peerServer(c, cur)
+	if !ok {
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&db.Peer{}).Where("uuid = ?", uuid).Updates(updates).Error; err != nil {
+			return err
+		}
+		if group != nil {
+			return applyGroupExpiry(tx, *group, []string{uuid})
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update peer failed: %v", err)})
 		return
 	}
-	var s db.Server
-	_ = db.DB.Limit(1).Find(&s).Error
 	var p db.Peer
-	_ = db.DB.Where("uuid = ?", uuid).First(&p).Error
-	cfg := config.LoadConfig()
+	_ = db.DB.Preload("Group").Where("uuid = ?", uuid).First(&p).Error
 	_, _ = wireguard.GeneratePeerConfig(cfg, s, p)
-	c.JSON(http.StatusOK, gin.H{"message": "peer updated"})
+	// Enabling, disabling or (un)expiring a peer adds or removes its [Peer] section on the server side,
+	// and together with group and firewall changes alters the ACL chain; rate limits are reloaded in place
+	activeChanged := req.Enabled != nil || req.ExpiresAt != nil
+	shapingChanged := req.RateUpKbit != nil || req.RateDownKbit != nil
+	resp := gin.H{"message": "peer updated"}
+	if activeChanged || req.GroupUUID != nil || req.firewallChanged() || shapingChanged {
+		var peers []db.Peer
+		_ = db.DB.Scopes(db.OnServer(s.UUID)).Find(&peers).Error
+		_ = wireguard.GenerateServerConfig(cfg, s, peers)
+		reload := wireguard.Reload{
+			Peers:   activeChanged,
+			ACL:     activeChanged || req.GroupUUID != nil || req.firewallChanged(),
+			Shaping: activeChanged || shapingChanged,
+		}
+		// A disabled or expired peer leaves the running interface now, even in manual mode
+		if activeChanged && !p.Active(time.Now()) {
+			reload.Revoke = []string{p.PublicKey}
+		}
+		withApply(resp, wireguard.ApplyChanges(cfg, reload))
+	}
+	// A raised, lowered or removed quota takes effect now rather than at the next sample
+	if req.quotaChanged() {
+		if err := stats.EnforceQuotas(cfg, time.Now()); err != nil {
+			log.Printf("[WG] quota check failed: %v", err)
+		}
+	}
+	fields := make([]string, 0, len(updates))
+	for col := range updates {
+		fields = append(fields, col)
+	}
+	sort.Strings(fields)
+	publishPeer(c, s.Interface, events.PeerUpdated, uuid, p.Name, map[string]any{"fields": fields})
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/v1/configs/peer/:uuid/rotate-keys -> Replace the peer's key pair and preshared key, keeping its address
+type RotatePeerKeysRequest struct {
+	// PublicKey is required for bring-your-own-key peers; giving it for other peers turns them into one
+	PublicKey *string `json:"public_key"`
+}
+
+func RotatePeerKeys(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var req RotatePeerKeysRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+	var p db.Peer
+	if err := db.DB.Preload("Group").Where("uuid = ?", uuid).First(&p).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return
+	}
+	cfg, s, ok := peerServer(c, p)
+	if !ok {
+		return
+	}
+	oldPublicKey := p.PublicKey
+
+	if req.PublicKey != nil {
+		if !wireguard.ValidKey(*req.PublicKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid public key"})
+			return
+		}
+		if *req.PublicKey == oldPublicKey {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new public key equals the current one"})
+			return
+		}
+		p.PrivateKey = nil
+		p.PublicKey = *req.PublicKey
+	} else if p.PrivateKey == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "public_key is required for bring-your-own-key peers"})
+		return
+	} else {
+		priv, pub, err := wireguard.GenerateKeyPair()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate peer key failed: %v", err)})
+			return
+		}
+		p.PrivateKey = &priv
+		p.PublicKey = pub
+	}
+	psk, err := wireguard.GeneratePresharedKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate preshared key failed: %v", err)})
+		return
+	}
+	p.PresharedKey = &psk
+
+	// peer_update_guard only lets the keys change with wgui.rotate set
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET LOCAL wgui.rotate = 'on'").Error; err != nil {
+			return err
+		}
+		return tx.Model(&db.Peer{}).Where("uuid = ?", uuid).Updates(map[string]any{"private_key": p.PrivateKey, "public_key": p.PublicKey, "preshared_key": p.PresharedKey}).Error
+	})
+	if db.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "public key already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("save peer key failed: %v", err)})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "peer.rotate_keys", uuid, fmt.Sprintf("old public key %s replaced by %s", oldPublicKey, p.PublicKey))
+
+	// Regenerate both sides of the configuration
+	path, err := wireguard.GeneratePeerConfig(cfg, s, p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate peer config failed: %v", err)})
+		return
+	}
+	var peers []db.Peer
+	_ = db.DB.Scopes(db.OnServer(s.UUID)).Find(&peers).Error
+	if err := wireguard.GenerateServerConfig(cfg, s, peers); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server config failed: %v", err)})
+		return
+	}
+
+	// The old key loses access right away, whatever WG_APPLY_MODE says; a lost device must not keep connecting.
+	// The new key is loaded as the mode asks, from the server config, which only carries it while the peer
+	// is active (not disabled, expired or suspended)
+	applied := wireguard.ApplyChanges(cfg, wireguard.Reload{Peers: true, Revoke: []string{oldPublicKey}})
+
+	publishPeer(c, s.Interface, events.PeerUpdated, uuid, p.Name, map[string]any{"fields": []string{"public_key", "preshared_key"}, "public_key": p.PublicKey})
+
+	resp := withApply(gin.H{"message": "peer keys rotated", "peer": p, "path": path}, applied)
+	c.JSON(http.StatusOK, resp)
 }
 
 // DELETE /api/v1/configs/peer/:uuid -> Delete peer
 func DeletePeer(c *gin.Context) {
 	uuid := c.Param("uuid")
+	var cur db.Peer
+	if err := db.DB.Select("uuid", "server_uuid").Where("uuid = ?", uuid).First(&cur).Error; err != nil {
+		// Already gone
+		c.JSON(http.StatusOK, gin.H{"message": "peer deleted"})
+		return
+	}
+	cfg, s, ok := peerServer(c, cur)
+	if !ok {
+		return
+	}
 	// Delete database row
-	if err := db.DB.Delete(&db.Peer{}, "uuid = ?", uuid).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete peer failed: %v", err)})
+	var deleted []db.Peer
+	res := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}, {Name: "name"}, {Name: "public_key"}}}).Where("uuid = ?", uuid).Delete(&deleted)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete peer failed: %v", res.Error)})
 		return
 	}
 	// Attempt to delete client configuration file
-	cfg := config.LoadConfig()
 	_ = os.Remove(filepath.Join(cfg.WGClientsDir, fmt.Sprintf("%s.conf", uuid)))
 	// Update server configuration
-	var s db.Server
-	_ = db.DB.Limit(1).Find(&s).Error
 	var peers []db.Peer
-	_ = db.DB.Find(&peers).Error
+	_ = db.DB.Scopes(db.OnServer(s.UUID)).Find(&peers).Error
 	_ = wireguard.GenerateServerConfig(cfg, s, peers)
-	c.JSON(http.StatusOK, gin.H{"message": "peer deleted"})
+	// The deleted peer leaves the running interface now, even in manual mode
+	reload := wireguard.ReloadAll
+	for _, p := range deleted {
+		reload.Revoke = append(reload.Revoke, p.PublicKey)
+	}
+	applied := wireguard.ApplyChanges(cfg, reload)
+	for _, p := range deleted {
+		publishPeer(c, s.Interface, events.PeerDeleted, p.UUID, p.Name, nil)
+	}
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "peer deleted"}, applied))
+}
+
+// loadServerAndPeer fetches a peer (with its group) and the server of its interface for config generation,
+// writing the error response itself when the peer is missing.
+func loadServerAndPeer(c *gin.Context, uuid string) (db.Server, db.Peer, bool) {
+	var p db.Peer
+	if err := db.DB.Preload("Group").Where("uuid = ?", uuid).First(&p).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return db.Server{}, p, false
+	}
+	_, s, ok := peerServer(c, p)
+	return s, p, ok
 }
 
-// GET /api/v1/configs/peer/:uuid -> Download peer configuration file
+// GET /api/v1/configs/peer/:uuid -> Download peer configuration file (?format=wg-quick|networkmanager|networkd|routeros|openwrt|json,
+// ?server_key=pending for the config that works after a staged server key rotation)
 func DownloadPeerConfig(c *gin.Context) {
-	uuid := c.Param("uuid")
-	var s db.Server
-	if err := db.DB.Limit(1).Find(&s).Error; err != nil || s.UUID == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "server not initialized"})
+	format := c.DefaultQuery("format", wireguard.FormatWGQuick)
+	if !wireguard.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format must be one of %s", strings.Join(wireguard.Formats, ", "))})
 		return
 	}
-	var p db.Peer
-	if err := db.DB.Where("uuid = ?", uuid).First(&p).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+	s, p, ok := loadServerAndPeer(c, c.Param("uuid"))
+	if !ok {
+		return
+	}
+	pending, ok := withPendingKey(c, &s)
+	if !ok {
+		return
+	}
+	if format == wireguard.FormatWGQuick && !pending {
+		servePeerConfig(c, s, p)
 		return
 	}
+	filename, contentType, data, err := wireguard.Export(s, p, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("export peer config failed: %v", err)})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// servePeerConfig regenerates the peer's client config and sends it as an attachment.
+func servePeerConfig(c *gin.Context, s db.Server, p db.Peer) {
 	cfg := config.LoadConfig()
 	path, err := wireguard.GeneratePeerConfig(cfg, s, p)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate peer config failed: %v", err)})
 		return
 	}
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.conf\"", uuid))
+	sendPeerConfig(c, p, path)
+}
+
+// sendPeerConfig sends a generated client config file as an attachment.
+func sendPeerConfig(c *gin.Context, p db.Peer, path string) {
+	// The file carries the peer's private key; keep it out of browser and proxy caches
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.conf\"", p.UUID))
 	c.File(path)
 }
+
+// GET /api/v1/configs/peer/:uuid/qr -> Peer configuration as a QR code (?format=png|svg&size=512&level=L|M|Q|H&server_key=current|pending)
+func PeerConfigQR(c *gin.Context) {
+	params, ok := parseQRParams(c)
+	if !ok {
+		return
+	}
+	s, p, ok := loadServerAndPeer(c, c.Param("uuid"))
+	if !ok {
+		return
+	}
+	if _, ok := withPendingKey(c, &s); !ok {
+		return
+	}
+	servePeerQR(c, s, p, params)
+}
+
+type qrParams struct {
+	format string
+	size   int
+	level  qr.Level
+}
+
+func parseQRParams(c *gin.Context) (qrParams, bool) {
+	params := qrParams{format: c.DefaultQuery("format", "png")}
+	if params.format != "png" && params.format != "svg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+		return params, false
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(qr.DefaultSize)))
+	if err != nil || size < qr.MinSize || size > qr.MaxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be between %d and %d", qr.MinSize, qr.MaxSize)})
+		return params, false
+	}
+	params.size = size
+	if params.level, err = qr.ParseLevel(c.Query("level")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return params, false
+	}
+	return params, true
+}
+
+// servePeerQR renders the peer's client config as a QR code image.
+func servePeerQR(c *gin.Context, s db.Server, p db.Peer, params qrParams) {
+	// A template with a placeholder key cannot be imported by scanning, and the server has no key to put in it
+	if p.PrivateKey == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "peer uses its own key pair; no QR code can be generated without its private key"})
+		return
+	}
+
+	img, contentType, err := renderPeerQR(s, p, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, img)
+}
+
+// renderPeerQR encodes the peer's client config as a QR code image and returns it with its content type.
+func renderPeerQR(s db.Server, p db.Peer, params qrParams) ([]byte, string, error) {
+	content := wireguard.RenderPeerConfig(s, p)
+	if params.format == "svg" {
+		img, err := qr.SVG(content, params.size, params.level)
+		if err != nil {
+			return nil, "", fmt.Errorf("generate qr code failed: %w", err)
+		}
+		return img, "image/svg+xml", nil
+	}
+	img, err := qr.PNG(content, params.size, params.level)
+	if err != nil {
+		return nil, "", fmt.Errorf("generate qr code failed: %w", err)
+	}
+	return img, "image/png", nil
+}
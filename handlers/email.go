@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/mailer"
+	"github.com/StellaShiina/wireguard-ui/qr"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+)
+
+const qrContentID = "peer-config-qr@wireguard-ui"
+
+// normalizeEmail validates an address and returns it without display name, or nil for "".
+func normalizeEmail(raw string) (*string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	addr, err := mail.ParseAddress(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email: %v", err)
+	}
+	return &addr.Address, nil
+}
+
+// POST /api/v1/configs/peer/:uuid/email -> Email the peer's config (attachment plus inline QR) to its stored address
+// (?server_key=pending sends the config for a staged server key rotation)
+func EmailPeerConfig(c *gin.Context) {
+	s, p, ok := loadServerAndPeer(c, c.Param("uuid"))
+	if !ok {
+		return
+	}
+	if _, ok := withPendingKey(c, &s); !ok {
+		return
+	}
+	if p.Email == nil || *p.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "peer has no email address"})
+		return
+	}
+	cfg := config.LoadConfig()
+	if cfg.SMTPHost == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": mailer.ErrNotConfigured.Error()})
+		return
+	}
+
+	content := wireguard.RenderPeerConfig(s, p)
+	data := mailer.PeerConfigData{
+		Name:            valOrEmpty(p.Name),
+		UUID:            p.UUID,
+		Address:         strings.Trim(valOrEmpty(p.IPv4)+", "+valOrEmpty(p.IPv6), ", "),
+		Endpoint:        wireguard.Endpoint(s),
+		BringYourOwnKey: p.PrivateKey == nil,
+	}
+	if p.ExpiresAt != nil {
+		data.ExpiresAt = p.ExpiresAt.Format(time.RFC1123)
+	}
+	attachments := []mailer.Attachment{{
+		Filename:    fmt.Sprintf("%s.conf", p.UUID),
+		ContentType: "text/plain; charset=utf-8",
+		Data:        []byte(content),
+	}}
+	// Same rule as the QR endpoint: no QR code for bring-your-own-key peers
+	if p.PrivateKey != nil {
+		img, err := qr.PNG(content, qr.DefaultSize, qr.Medium)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate qr code failed: %v", err)})
+			return
+		}
+		attachments = append(attachments, mailer.Attachment{Filename: "wireguard-qr.png", ContentType: "image/png", Data: img, Inline: true, ContentID: qrContentID})
+		data.HasQR = true
+		data.QRContentID = qrContentID
+	}
+	subject, text, html, err := mailer.RenderPeerConfig(cfg.EmailTmplDir, data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("render email template failed: %v", err)})
+		return
+	}
+
+	sendErr := mailer.Send(cfg, mailer.Message{
+		From:        cfg.SMTPFrom,
+		To:          *p.Email,
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		Attachments: attachments,
+	})
+	delivery := db.EmailDelivery{PeerUUID: p.UUID, CreatedAt: time.Now(), Recipient: *p.Email, Subject: subject, Status: "sent"}
+	if sendErr != nil {
+		msg := sendErr.Error()
+		delivery.Status = "failed"
+		delivery.Error = &msg
+	}
+	if err := db.DB.Create(&delivery).Error; err != nil {
+		sendErr = errors.Join(sendErr, fmt.Errorf("record delivery: %w", err))
+	}
+	if sendErr != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("send email failed: %v", sendErr), "delivery": delivery})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email sent", "delivery": delivery})
+}
+
+// GET /api/v1/configs/peer/:uuid/email -> Delivery history of the peer's config emails, newest first
+func GetEmailDeliveries(c *gin.Context) {
+	var deliveries []db.EmailDelivery
+	if err := db.DB.Where("peer_uuid = ?", c.Param("uuid")).Order("created_at DESC").Limit(100).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query deliveries failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+func valOrEmpty(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/gin-gonic/gin"
+)
+
+// sseKeepalive is how often an idle stream gets a comment line, so proxies do not time it out.
+const sseKeepalive = 20 * time.Second
+
+// publishPeer publishes an event about a peer on the named interface on behalf of the logged-in user.
+func publishPeer(c *gin.Context, iface, typ, uuid string, name *string, data map[string]any) {
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["name"] = name
+	events.Publish(events.Event{Type: typ, Interface: iface, PeerUUID: uuid, Actor: c.GetString("username"), Data: data})
+}
+
+// GET /api/v1/events -> Server-Sent Events stream of state changes
+// Query: types (comma-separated, "peer.*" matches a family). Resumes after the Last-Event-ID header (or last_event_id).
+func Events(c *gin.Context) {
+	var types []string
+	for _, t := range strings.Split(c.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var after int64
+	if lastID != "" {
+		n, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+			return
+		}
+		after = n
+	}
+
+	ch, cancel := events.Subscribe(types, after)
+	defer cancel()
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Connection", "keep-alive")
+	// Disable response buffering in nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// Ask EventSource to reconnect after 3s and flush the headers right away
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				// Fell behind; the client reconnects with its last event ID and catches up from the history
+				return false
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return true
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			return true
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
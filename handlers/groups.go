@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/stats"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PeerSettingsRequest carries the client settings shared by groups and per-peer overrides.
+// An empty string or zero clears the setting so that it is inherited again.
+type PeerSettingsRequest struct {
+	AllowedIPs          *string `json:"allowed_ips"`
+	DNS                 *string `json:"dns"`
+	PersistentKeepalive *int    `json:"persistent_keepalive"`
+	MTU                 *int    `json:"mtu"`
+	// Firewall settings: peer_to_peer is "allow" or "deny", acl_default is "accept" or "drop"
+	PeerToPeer *string `json:"peer_to_peer"`
+	ACLDefault *string `json:"acl_default"`
+	// Data quota: limit in bytes (0 clears), quota_direction rx, tx or total, quota_period day, week, month or never
+	QuotaBytes     *int64  `json:"quota_bytes"`
+	QuotaDirection *string `json:"quota_direction"`
+	QuotaPeriod    *string `json:"quota_period"`
+}
+
+// quotaChanged reports whether the request touches the data quota.
+func (r PeerSettingsRequest) quotaChanged() bool {
+	return r.QuotaBytes != nil || r.QuotaDirection != nil || r.QuotaPeriod != nil
+}
+
+// firewallChanged reports whether the request touches settings compiled into the ACL script.
+func (r PeerSettingsRequest) firewallChanged() bool {
+	return r.PeerToPeer != nil || r.ACLDefault != nil
+}
+
+func (r PeerSettingsRequest) updates() (map[string]any, error) {
+	updates := map[string]any{}
+	if r.AllowedIPs != nil {
+		v, err := normalizeList(*r.AllowedIPs, func(item string) error {
+			_, err := netip.ParsePrefix(item)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed_ips: %v", err)
+		}
+		updates["allowed_ips"] = v
+	}
+	if r.DNS != nil {
+		// wg-quick accepts both resolver addresses and search domains here
+		v, err := normalizeList(*r.DNS, func(item string) error {
+			if strings.ContainsAny(item, " \t/") {
+				return fmt.Errorf("%q is not an address or domain", item)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns: %v", err)
+		}
+		updates["dns"] = v
+	}
+	if r.PersistentKeepalive != nil {
+		switch v := *r.PersistentKeepalive; {
+		case v == 0:
+			updates["persistent_keepalive"] = nil
+		case v < 0 || v > 65535:
+			return nil, errors.New("persistent_keepalive must be between 0 and 65535")
+		default:
+			updates["persistent_keepalive"] = v
+		}
+	}
+	if r.MTU != nil {
+		switch v := *r.MTU; {
+		case v == 0:
+			updates["mtu"] = nil
+		case v < 1280 || v > 9000:
+			return nil, errors.New("mtu must be between 1280 and 9000")
+		default:
+			updates["mtu"] = v
+		}
+	}
+	if r.PeerToPeer != nil {
+		switch strings.ToLower(*r.PeerToPeer) {
+		case "":
+			updates["isolated"] = nil
+		case "allow":
+			updates["isolated"] = false
+		case "deny":
+			updates["isolated"] = true
+		default:
+			return nil, errors.New("peer_to_peer must be allow or deny")
+		}
+	}
+	if r.ACLDefault != nil {
+		switch v := strings.ToLower(*r.ACLDefault); v {
+		case "":
+			updates["acl_default"] = nil
+		case db.ACLAccept, db.ACLDrop:
+			updates["acl_default"] = v
+		default:
+			return nil, errors.New("acl_default must be accept or drop")
+		}
+	}
+	if r.QuotaBytes != nil {
+		switch v := *r.QuotaBytes; {
+		case v == 0:
+			updates["quota_bytes"] = nil
+		case v < 0:
+			return nil, errors.New("quota_bytes must not be negative")
+		default:
+			updates["quota_bytes"] = v
+		}
+	}
+	if r.QuotaDirection != nil {
+		switch v := strings.ToLower(*r.QuotaDirection); v {
+		case "":
+			updates["quota_direction"] = nil
+		case db.QuotaRx, db.QuotaTx, db.QuotaTotal:
+			updates["quota_direction"] = v
+		default:
+			return nil, errors.New("quota_direction must be rx, tx or total")
+		}
+	}
+	if r.QuotaPeriod != nil {
+		switch v := strings.ToLower(*r.QuotaPeriod); v {
+		case "":
+			updates["quota_period"] = nil
+		case db.QuotaDay, db.QuotaWeek, db.QuotaMonth, db.QuotaNever:
+			updates["quota_period"] = v
+		default:
+			return nil, errors.New("quota_period must be day, week, month or never")
+		}
+	}
+	return updates, nil
+}
+
+// normalizeList validates a comma-separated list and returns it re-joined, or nil when empty.
+func normalizeList(raw string, check func(string) error) (any, error) {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if err := check(item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// applyGroupExpiry gives peers that just joined g an expiry date when the group has an expiry policy
+// and the peer has none of its own.
+func applyGroupExpiry(tx *gorm.DB, g db.PeerGroup, peerUUIDs []string) error {
+	if g.ExpiryDays == nil || *g.ExpiryDays <= 0 || len(peerUUIDs) == 0 {
+		return nil
+	}
+	return tx.Model(&db.Peer{}).
+		Where("uuid IN ? AND expires_at IS NULL", peerUUIDs).
+		Update("expires_at", gorm.Expr("now() + make_interval(days => ?)", *g.ExpiryDays)).Error
+}
+
+// GET /api/v1/groups -> List groups with member counts
+func GetGroups(c *gin.Context) {
+	var groups []db.PeerGroup
+	if err := db.DB.Order("name").Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query groups failed: %v", err)})
+		return
+	}
+	type row struct {
+		GroupUUID string
+		Count     int64
+	}
+	var rows []row
+	if err := db.DB.Model(&db.Peer{}).Select("group_uuid, count(*) AS count").Where("group_uuid IS NOT NULL").Group("group_uuid").Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count members failed: %v", err)})
+		return
+	}
+	counts := map[string]int64{}
+	for _, r := range rows {
+		counts[r.GroupUUID] = r.Count
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups, "members": counts})
+}
+
+// POST /api/v1/groups -> Create group
+// PUT /api/v1/groups/:uuid -> Update group (any subset of fields)
+type GroupRequest struct {
+	Name *string `json:"name"`
+	PeerSettingsRequest
+	ExpiryDays *int `json:"expiry_days"`
+}
+
+func (r GroupRequest) updates() (map[string]any, error) {
+	updates, err := r.PeerSettingsRequest.updates()
+	if err != nil {
+		return nil, err
+	}
+	if r.Name != nil {
+		if strings.TrimSpace(*r.Name) == "" {
+			return nil, errors.New("name must not be empty")
+		}
+		updates["name"] = strings.TrimSpace(*r.Name)
+	}
+	if r.ExpiryDays != nil {
+		switch v := *r.ExpiryDays; {
+		case v == 0:
+			updates["expiry_days"] = nil
+		case v < 0:
+			return nil, errors.New("expiry_days must not be negative")
+		default:
+			updates["expiry_days"] = v
+		}
+	}
+	return updates, nil
+}
+
+func CreateGroup(c *gin.Context) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	updates, err := req.updates()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	g := db.PeerGroup{Name: updates["name"].(string)}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&g).Error; err != nil {
+			return err
+		}
+		return tx.Model(&g).Updates(updates).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create group failed: %v", err)})
+		return
+	}
+	_ = db.DB.Where("uuid = ?", g.UUID).First(&g).Error
+	c.JSON(http.StatusOK, gin.H{"group": g})
+}
+
+func UpdateGroup(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var g db.PeerGroup
+	if err := db.DB.Where("uuid = ?", uuid).First(&g).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return
+	}
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	updates, err := req.updates()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+	if err := db.DB.Model(&db.PeerGroup{}).Where("uuid = ?", uuid).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update group failed: %v", err)})
+		return
+	}
+	// Member client configs inherit the new defaults
+	cfg := config.LoadConfig()
+	if err := wireguard.WriteAllConfigs(cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("regenerate configs failed: %v", err)})
+		return
+	}
+	resp := gin.H{}
+	if req.firewallChanged() {
+		resp = withApply(resp, wireguard.ApplyAllChanges(cfg, wireguard.Reload{ACL: true}))
+	}
+	if req.quotaChanged() {
+		if err := stats.EnforceQuotas(cfg, time.Now()); err != nil {
+			log.Printf("[WG] quota check failed: %v", err)
+		}
+	}
+	_ = db.DB.Where("uuid = ?", uuid).First(&g).Error
+	resp["group"] = g
+	c.JSON(http.StatusOK, resp)
+}
+
+// DELETE /api/v1/groups/:uuid -> Delete group; members stay and fall back to their own settings
+func DeleteGroup(c *gin.Context) {
+	uuid := c.Param("uuid")
+	res := db.DB.Delete(&db.PeerGroup{}, "uuid = ?", uuid)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete group failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return
+	}
+	cfg := config.LoadConfig()
+	applied := reloadFirewall(cfg)
+	// Members fall back to their own quota, which may suspend or resume them
+	if err := stats.EnforceQuotas(cfg, time.Now()); err != nil {
+		log.Printf("[WG] quota check failed: %v", err)
+	}
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "group deleted"}, applied))
+}
+
+// POST /api/v1/groups/:uuid/peers -> Move peers into the group
+type GroupMembersRequest struct {
+	PeerUUIDs []string `json:"peer_uuids"`
+}
+
+// validUUIDs reports whether every id is a UUID; a malformed one would otherwise fail in the database.
+func validUUIDs(ids ...string) bool {
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func AddGroupPeers(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var g db.PeerGroup
+	if err := db.DB.Where("uuid = ?", uuid).First(&g).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return
+	}
+	var req GroupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.PeerUUIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !validUUIDs(req.PeerUUIDs...) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid peer uuid"})
+		return
+	}
+	var count int64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&db.Peer{}).Where("uuid IN ?", req.PeerUUIDs).Update("group_uuid", g.UUID)
+		if res.Error != nil {
+			return res.Error
+		}
+		count = res.RowsAffected
+		return applyGroupExpiry(tx, g, req.PeerUUIDs)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update membership failed: %v", err)})
+		return
+	}
+	cfg := config.LoadConfig()
+	applied := reloadFirewall(cfg)
+	// The moved peers now fall under the group's quota, which may suspend or resume them
+	if err := stats.EnforceQuotas(cfg, time.Now()); err != nil {
+		log.Printf("[WG] quota check failed: %v", err)
+	}
+	var moved []db.Peer
+	_ = db.DB.Select("uuid", "name", "server_uuid").Where("uuid IN ? AND group_uuid = ?", req.PeerUUIDs, g.UUID).Find(&moved).Error
+	names := interfaceNames()
+	for _, p := range moved {
+		publishPeer(c, names[p.ServerUUID], events.PeerUpdated, p.UUID, p.Name, map[string]any{"fields": []string{"group_uuid"}, "group_uuid": g.UUID})
+	}
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "peers added to group", "count": count}, applied))
+}
+
+// DELETE /api/v1/groups/:uuid/peers/:peer -> Remove a peer from the group
+func RemoveGroupPeer(c *gin.Context) {
+	if !validUUIDs(c.Param("peer"), c.Param("uuid")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer is not a member of this group"})
+		return
+	}
+	var removed []db.Peer
+	res := db.DB.Model(&removed).Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}, {Name: "name"}, {Name: "server_uuid"}}}).
+		Where("uuid = ? AND group_uuid = ?", c.Param("peer"), c.Param("uuid")).Update("group_uuid", nil)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update membership failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer is not a member of this group"})
+		return
+	}
+	cfg := config.LoadConfig()
+	applied := reloadFirewall(cfg)
+	// The peer falls back to its own quota, which may suspend or resume it
+	if err := stats.EnforceQuotas(cfg, time.Now()); err != nil {
+		log.Printf("[WG] quota check failed: %v", err)
+	}
+	names := interfaceNames()
+	for _, p := range removed {
+		publishPeer(c, names[p.ServerUUID], events.PeerUpdated, p.UUID, p.Name, map[string]any{"fields": []string{"group_uuid"}, "group_uuid": nil})
+	}
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "peer removed from group"}, applied))
+}
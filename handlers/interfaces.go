@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/netutil"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Interface names end up in file names, systemd units and firewall chains; Linux limits them to 15 bytes.
+var ifaceNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,14}$`)
+
+// interfaceOf resolves the interface a request works on: :name under /api/v1/interfaces/:name, WG_INTERFACE
+// on the plain /api/v1 routes. It returns the config pointing at it and writes the error response itself.
+func interfaceOf(c *gin.Context) (*config.Config, db.Server, bool) {
+	cfg := config.LoadConfig()
+	name := c.Param("name")
+	if name == "" {
+		name = cfg.WGInterface
+	}
+	s, err := db.ServerByInterface(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("interface %s not found", name)})
+		return nil, s, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query server failed: %v", err)})
+		return nil, s, false
+	}
+	return wireguard.ConfigFor(cfg, s), s, true
+}
+
+// serverOf loads the server of a /configs/server/:uuid route. Under /api/v1/interfaces/:name it must be
+// that interface's.
+func serverOf(c *gin.Context) (*config.Config, db.Server, bool) {
+	var s db.Server
+	if err := db.DB.Where("uuid = ?", c.Param("uuid")).First(&s).Error; err != nil || !onRouteInterface(c, s) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "server not found"})
+		return nil, s, false
+	}
+	return wireguard.ConfigFor(config.LoadConfig(), s), s, true
+}
+
+// peerServer loads the server a peer belongs to, with the config pointing at its interface. Peers are
+// addressed by UUID, so the plain routes find them on any interface; under /api/v1/interfaces/:name a
+// peer of another interface is not found.
+func peerServer(c *gin.Context, p db.Peer) (*config.Config, db.Server, bool) {
+	var s db.Server
+	if err := db.DB.Where("uuid = ?", p.ServerUUID).First(&s).Error; err != nil || !onRouteInterface(c, s) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return nil, s, false
+	}
+	return wireguard.ConfigFor(config.LoadConfig(), s), s, true
+}
+
+func onRouteInterface(c *gin.Context, s db.Server) bool {
+	name := c.Param("name")
+	return name == "" || name == s.Interface
+}
+
+// checkSubnets refuses subnets overlapping those of another interface: peer addresses are unique across
+// the instance and the kernel could not route them apart.
+func checkSubnets(self string, v4, v6 *netip.Prefix) error {
+	servers, err := db.Servers()
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		if s.UUID == self {
+			continue
+		}
+		for _, pair := range [][2]string{{prefixString(v4), s.SubnetV4}, {prefixString(v6), s.SubnetV6}} {
+			mine, err1 := netip.ParsePrefix(pair[0])
+			theirs, err2 := netip.ParsePrefix(pair[1])
+			if err1 == nil && err2 == nil && mine.Overlaps(theirs) {
+				return fmt.Errorf("subnet %s overlaps %s of interface %s", mine, theirs, s.Interface)
+			}
+		}
+	}
+	return nil
+}
+
+func prefixString(p *netip.Prefix) string {
+	if p == nil {
+		return ""
+	}
+	return p.String()
+}
+
+// interfaceNames maps server UUIDs to interface names, for events about peers of several interfaces.
+func interfaceNames() map[string]string {
+	names := map[string]string{}
+	servers, _ := db.Servers()
+	for _, s := range servers {
+		names[s.UUID] = s.Interface
+	}
+	return names
+}
+
+func serviceName(cfg *config.Config) string {
+	return fmt.Sprintf("%s-quick@%s", cfg.WGMode, cfg.WGInterface)
+}
+
+// InterfaceInfo is a managed interface with its peer count and whether it is running.
+type InterfaceInfo struct {
+	Server  db.Server `json:"server"`
+	Service string    `json:"service"`
+	Up      bool      `json:"up"`
+	Peers   int64     `json:"peers"`
+	// Default is the interface the plain /api/v1 routes work on (WG_INTERFACE)
+	Default bool `json:"default"`
+}
+
+func interfaceInfo(cfg *config.Config, s db.Server, peers int64) InterfaceInfo {
+	ifc := wireguard.ConfigFor(cfg, s)
+	return InterfaceInfo{Server: s, Service: serviceName(ifc), Up: wireguard.InterfaceUp(ifc), Peers: peers, Default: s.Interface == cfg.WGInterface}
+}
+
+// GET /api/v1/interfaces -> Managed interfaces
+func ListInterfaces(c *gin.Context) {
+	cfg := config.LoadConfig()
+	servers, err := db.Servers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query servers failed: %v", err)})
+		return
+	}
+	var counts []struct {
+		ServerUUID string
+		Count      int64
+	}
+	if err := db.DB.Model(&db.Peer{}).Select("server_uuid, count(*) AS count").Group("server_uuid").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count peers failed: %v", err)})
+		return
+	}
+	perServer := map[string]int64{}
+	for _, n := range counts {
+		perServer[n.ServerUUID] = n.Count
+	}
+	out := make([]InterfaceInfo, len(servers))
+	for i, s := range servers {
+		out[i] = interfaceInfo(cfg, s, perServer[s.UUID])
+	}
+	c.JSON(http.StatusOK, gin.H{"interfaces": out})
+}
+
+// GET /api/v1/interfaces/:name -> One interface
+func GetInterface(c *gin.Context) {
+	_, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	var peers int64
+	if err := db.DB.Model(&db.Peer{}).Scopes(db.OnServer(s.UUID)).Count(&peers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count peers failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"interface": interfaceInfo(config.LoadConfig(), s, peers)})
+}
+
+// POST /api/v1/interfaces -> Add an interface with its own port, subnets and key pair
+type CreateInterfaceRequest struct {
+	Name string `json:"name"`
+	// PublicIP defaults to that of the default interface
+	PublicIP   *string `json:"public_ip"`
+	Port       int     `json:"port"`
+	EnableIPv6 *bool   `json:"enable_ipv6"`
+	SubnetV4   string  `json:"subnet_v4"`
+	SubnetV6   string  `json:"subnet_v6"`
+	// Node is the UUID of the node whose agent runs the interface; empty for this host
+	Node *string `json:"node"`
+}
+
+func CreateInterface(c *gin.Context) {
+	var req CreateInterfaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !ifaceNameRe.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-15 letters, digits, '_', '-' or '.'"})
+		return
+	}
+	if req.Port < 1 || req.Port > 65535 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "port must be between 1 and 65535"})
+		return
+	}
+	v4, err := netutil.ParseSubnet("ipv4", req.SubnetV4)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	v6, err := netutil.ParseSubnet("ipv6", req.SubnetV6)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkSubnets("", &v4, &v6); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Node != nil {
+		if err := db.DB.Where("uuid = ?", *req.Node).First(&db.Node{}).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "node not found"})
+			return
+		}
+	}
+	// Ports only clash on the same host; names are unique across the instance
+	var taken int64
+	if err := db.DB.Model(&db.Server{}).Where("interface = ? OR (port = ? AND node_uuid IS NOT DISTINCT FROM ?)", req.Name, req.Port, req.Node).Count(&taken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query servers failed: %v", err)})
+		return
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "an interface with this name, or with this port on the same host, already exists"})
+		return
+	}
+
+	cfg := config.LoadConfig()
+	s := db.Server{Interface: req.Name, Port: req.Port, EnableIPv6: true, SubnetV4: v4.String(), SubnetV6: v6.String(), NodeUUID: req.Node, DesiredUp: true}
+	if req.EnableIPv6 != nil {
+		s.EnableIPv6 = *req.EnableIPv6
+	}
+	if req.PublicIP != nil {
+		s.PublicIP = *req.PublicIP
+	} else if def, err := db.ServerByInterface(cfg.WGInterface); err == nil {
+		s.PublicIP = def.PublicIP
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "public_ip is required"})
+		return
+	}
+	if s.PrivateKey, s.PublicKey, err = wireguard.GenerateKeyPair(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server key failed: %v", err)})
+		return
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&s).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create interface failed: %v", err)})
+		return
+	}
+	detail := fmt.Sprintf("%s port %d, %s, %s", s.Interface, s.Port, s.SubnetV4, s.SubnetV6)
+	if s.NodeUUID != nil {
+		detail += " on node " + *s.NodeUUID
+	}
+	_ = db.RecordAudit(c.GetString("username"), "interface.create", "", detail)
+
+	ifc := wireguard.ConfigFor(cfg, s)
+	if err := wireguard.WriteInterfaceConfigs(ifc, s); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server config failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"interface": interfaceInfo(cfg, s, 0)})
+}
+
+// DELETE /api/v1/interfaces/:name -> Remove a stopped interface without peers and its config files
+func DeleteInterface(c *gin.Context) {
+	ifc, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	if s.Interface == config.LoadConfig().WGInterface {
+		c.JSON(http.StatusConflict, gin.H{"error": "the default interface (WG_INTERFACE) cannot be deleted"})
+		return
+	}
+	if wireguard.InterfaceUp(ifc) {
+		c.JSON(http.StatusConflict, gin.H{"error": "interface is running; stop it first"})
+		return
+	}
+	var peers int64
+	if err := db.DB.Model(&db.Peer{}).Scopes(db.OnServer(s.UUID)).Count(&peers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count peers failed: %v", err)})
+		return
+	}
+	if peers > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("interface has %d peers; delete them first", peers)})
+		return
+	}
+	if err := db.DB.Delete(&db.Server{}, "uuid = ?", s.UUID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete interface failed: %v", err)})
+		return
+	}
+	// best-effort: the service is stopped, so nothing uses the files anymore; a node agent removes its own copies
+	_ = os.Remove(filepath.Join(ifc.WGConfDir, fmt.Sprintf("%s.conf", s.Interface)))
+	_ = os.Remove(wireguard.ACLScriptPath(ifc))
+	_ = os.Remove(wireguard.ShapingScriptPath(ifc))
+	_ = db.RecordAudit(c.GetString("username"), "interface.delete", "", s.Interface)
+	c.JSON(http.StatusOK, gin.H{"message": "interface deleted"})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsHandler = promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+
+// GET /metrics -> Prometheus metrics; requires "Authorization: Bearer <METRICS_TOKEN>" when the token is set
+func Metrics(c *gin.Context) {
+	cfg := config.LoadConfig()
+	if cfg.MetricsToken != "" {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid metrics token"})
+			return
+		}
+	}
+	metricsHandler.ServeHTTP(c.Writer, c.Request)
+}
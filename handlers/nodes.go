@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/agent"
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// NodeInfo is a node with the interfaces assigned to it and whether its agent reported recently.
+type NodeInfo struct {
+	db.Node
+	Online     bool     `json:"Online"`
+	Interfaces []string `json:"Interfaces"`
+}
+
+func newNodeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GET /api/v1/nodes -> Remote nodes and the interfaces their agents run
+func ListNodes(c *gin.Context) {
+	var nodes []db.Node
+	if err := db.DB.Order("name").Find(&nodes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query nodes failed: %v", err)})
+		return
+	}
+	servers, err := db.Servers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query servers failed: %v", err)})
+		return
+	}
+	ifaces := map[string][]string{}
+	for _, s := range servers {
+		if s.NodeUUID != nil {
+			ifaces[*s.NodeUUID] = append(ifaces[*s.NodeUUID], s.Interface)
+		}
+	}
+	now := time.Now()
+	out := make([]NodeInfo, len(nodes))
+	for i, n := range nodes {
+		out[i] = NodeInfo{Node: n, Online: n.LastSeenAt != nil && now.Sub(*n.LastSeenAt) < wireguard.ReportTTL, Interfaces: ifaces[n.UUID]}
+		if out[i].Interfaces == nil {
+			out[i].Interfaces = []string{}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"nodes": out})
+}
+
+type CreateNodeRequest struct {
+	Name string `json:"name"`
+}
+
+// POST /api/v1/nodes -> Register a node; the agent token is only returned here
+func CreateNode(c *gin.Context) {
+	var req CreateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	token, err := newNodeToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate token failed: %v", err)})
+		return
+	}
+	n := db.Node{Name: strings.TrimSpace(req.Name), TokenHash: db.HashNodeToken(token), CreatedAt: time.Now()}
+	var taken int64
+	if err := db.DB.Model(&db.Node{}).Where("name = ?", n.Name).Count(&taken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query nodes failed: %v", err)})
+		return
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "a node with this name already exists"})
+		return
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&n).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create node failed: %v", err)})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "node.create", "", n.Name)
+	c.JSON(http.StatusOK, gin.H{"node": n, "token": token})
+}
+
+// POST /api/v1/nodes/:uuid/token -> Issue a new agent token; the old one stops working
+func RotateNodeToken(c *gin.Context) {
+	var n db.Node
+	if err := db.DB.Where("uuid = ?", c.Param("uuid")).First(&n).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+	token, err := newNodeToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate token failed: %v", err)})
+		return
+	}
+	if err := db.DB.Model(&db.Node{}).Where("uuid = ?", n.UUID).Update("token_hash", db.HashNodeToken(token)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("save token failed: %v", err)})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "node.rotate_token", "", n.Name)
+	c.JSON(http.StatusOK, gin.H{"node": n, "token": token})
+}
+
+// DELETE /api/v1/nodes/:uuid -> Remove a node without interfaces
+func DeleteNode(c *gin.Context) {
+	var n db.Node
+	if err := db.DB.Where("uuid = ?", c.Param("uuid")).First(&n).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+	var ifaces int64
+	if err := db.DB.Model(&db.Server{}).Where("node_uuid = ?", n.UUID).Count(&ifaces).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count interfaces failed: %v", err)})
+		return
+	}
+	if ifaces > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("node runs %d interfaces; delete them first", ifaces)})
+		return
+	}
+	if err := db.DB.Delete(&db.Node{}, "uuid = ?", n.UUID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete node failed: %v", err)})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "node.delete", "", n.Name)
+	c.JSON(http.StatusOK, gin.H{"message": "node deleted"})
+}
+
+// nodeConfig is the config the files of a node are rendered with: the panel's, with the paths, NAT
+// interface and wg flavour the agent reported.
+func nodeConfig(rep agent.Report) *config.Config {
+	cfg := config.LoadConfig()
+	cfg.WGConfDir = rep.ConfDir
+	cfg.WGExternalIF = rep.ExternalIF
+	if rep.WGMode != "" {
+		cfg.WGMode = rep.WGMode
+	}
+	return cfg
+}
+
+// POST /agent/v1/sync -> Node agent reports its interfaces and receives their desired state (Bearer node token)
+func AgentSync(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	var n db.Node
+	if !found || token == "" || db.DB.Where("token_hash = ?", db.HashNodeToken(token)).First(&n).Error != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid node token"})
+		return
+	}
+	var rep agent.Report
+	if err := c.ShouldBindJSON(&rep); err != nil || rep.ConfDir == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report"})
+		return
+	}
+
+	var servers []db.Server
+	if err := db.DB.Where("node_uuid = ?", n.UUID).Order("interface").Find(&servers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query servers failed: %v", err)})
+		return
+	}
+	now := time.Now()
+	assigned := map[string]bool{}
+	for _, s := range servers {
+		assigned[s.Interface] = true
+	}
+	var lastError *string
+	for _, r := range rep.Interfaces {
+		// A node only speaks for its own interfaces
+		if !assigned[r.Name] {
+			continue
+		}
+		wireguard.ReportRemote(r.Name, wireguard.RemoteState{Up: r.Up, ServiceState: r.ServiceState, Dump: r.Dump, Error: r.Error, ReportedAt: now})
+		if r.Error != "" && lastError == nil {
+			e := fmt.Sprintf("%s: %s", r.Name, r.Error)
+			lastError = &e
+		}
+	}
+	updates := map[string]any{"last_seen_at": now, "address": c.ClientIP(), "hostname": rep.Hostname, "version": rep.Version,
+		"conf_dir": rep.ConfDir, "external_if": rep.ExternalIF, "wg_mode": rep.WGMode, "last_error": lastError}
+	if err := db.DB.Model(&db.Node{}).Where("uuid = ?", n.UUID).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update node failed: %v", err)})
+		return
+	}
+
+	cfg := nodeConfig(rep)
+	desired := agent.Desired{Interfaces: []agent.DesiredInterface{}}
+	for _, s := range servers {
+		if err := ensureServerKey(&s); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server key failed: %v", err)})
+			return
+		}
+		// A stable peer order keeps the files identical between syncs, so the agent only restarts on real changes
+		var peers []db.Peer
+		if err := db.DB.Scopes(db.OnServer(s.UUID)).Preload("Group").Order("ipv4, created_at").Find(&peers).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
+			return
+		}
+		files, err := wireguard.RenderServerFiles(wireguard.ConfigFor(cfg, s), s, peers)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("render %s failed: %v", s.Interface, err)})
+			return
+		}
+		desired.Interfaces = append(desired.Interfaces, agent.DesiredInterface{Name: s.Interface, Up: s.DesiredUp, Files: files})
+	}
+	c.JSON(http.StatusOK, desired)
+}
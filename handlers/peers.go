@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/stats"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Sort keys accepted by ListPeers; a leading "-" reverses the order.
+var peerSortColumns = map[string]string{
+	"name":       "name",
+	"created_at": "created_at",
+	"ipv4":       "ipv4",
+	"expires_at": "expires_at",
+}
+
+// GET /api/v1/peers -> Search, filter, sort and paginate peers
+//
+// Query parameters:
+//   - q: case-insensitive substring of the name, IPv4 or IPv6 address
+//   - group: group uuid, or "none" for peers without a group
+//   - tag: may be repeated; peers must carry every given tag
+//   - status: enabled, disabled, expired, suspended (over quota) or active (enabled, not expired and not suspended)
+//   - sort: name, created_at, ipv4 or expires_at, prefixed with "-" for descending (default created_at)
+//   - limit, offset: page size (default 50, max 500) and offset
+func ListPeers(c *gin.Context) {
+	_, srv, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	q := db.DB.Model(&db.Peer{}).Scopes(db.OnServer(srv.UUID))
+
+	if term := strings.TrimSpace(c.Query("q")); term != "" {
+		like := "%" + escapeLike(term) + "%"
+		q = q.Where("name ILIKE ? OR host(ipv4) LIKE ? OR host(ipv6) ILIKE ?", like, like, like)
+	}
+	switch group := c.Query("group"); group {
+	case "":
+	case "none":
+		q = q.Where("group_uuid IS NULL")
+	default:
+		if !validUUIDs(group) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group uuid"})
+			return
+		}
+		q = q.Where("group_uuid = ?", group)
+	}
+	for _, tag := range c.QueryArray("tag") {
+		q = q.Where("tags @> ?::jsonb", db.StringList{tag})
+	}
+	switch status := c.Query("status"); status {
+	case "":
+	case "enabled":
+		q = q.Where("enabled")
+	case "disabled":
+		q = q.Where("NOT enabled")
+	case "expired":
+		q = q.Where("expires_at <= now()")
+	case "suspended":
+		q = q.Where("quota_exceeded_at IS NOT NULL")
+	case "active":
+		q = q.Where("enabled AND (expires_at IS NULL OR expires_at > now()) AND quota_exceeded_at IS NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown status %q", status)})
+		return
+	}
+
+	sortKey := c.DefaultQuery("sort", "created_at")
+	dir := "ASC"
+	if strings.HasPrefix(sortKey, "-") {
+		sortKey, dir = sortKey[1:], "DESC"
+	}
+	column, ok := peerSortColumns[sortKey]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown sort key %q", sortKey)})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	// Count on a separate session so the shared filters are reused untouched by the page query
+	var total int64
+	if err := q.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count peers failed: %v", err)})
+		return
+	}
+	var peers []db.Peer
+	// uuid breaks ties so that pages do not overlap when the sort column has duplicates
+	order := fmt.Sprintf("%s %s NULLS LAST, uuid", column, dir)
+	if err := q.Preload("Group").Order(order).Limit(limit).Offset(offset).Find(&peers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
+		return
+	}
+	if err := stats.AttachQuotas(peers, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query quotas failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"peers": peers, "total": total, "limit": limit, "offset": offset})
+}
+
+// escapeLike escapes the LIKE wildcards in user input.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// normalizeTags trims, drops empty and duplicate tags, keeping the first occurrence order.
+func normalizeTags(tags []string) db.StringList {
+	seen := map[string]bool{}
+	out := db.StringList{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
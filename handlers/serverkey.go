@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+)
+
+// ensureServerKey replaces the placeholder key of a fresh install (wg keys are 44 characters) with a real one.
+func ensureServerKey(s *db.Server) error {
+	if len(s.PrivateKey) >= 40 && len(s.PublicKey) >= 40 {
+		return nil
+	}
+	priv, pub, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := db.DB.Model(&db.Server{}).Where("uuid = ?", s.UUID).Updates(map[string]any{"private_key": priv, "public_key": pub}).Error; err != nil {
+		return err
+	}
+	s.PrivateKey, s.PublicKey = priv, pub
+	return nil
+}
+
+// usePendingKey switches s to its staged key pair, reporting false when no rotation is staged.
+func usePendingKey(s *db.Server) bool {
+	if s.PendingPrivateKey == nil || s.PendingPublicKey == nil {
+		return false
+	}
+	s.PrivateKey, s.PublicKey = *s.PendingPrivateKey, *s.PendingPublicKey
+	return true
+}
+
+// withPendingKey switches s to the staged key pair when the request asks for ?server_key=pending, writing
+// the error response itself when the parameter is invalid or no rotation is staged.
+func withPendingKey(c *gin.Context, s *db.Server) (pending, ok bool) {
+	switch c.DefaultQuery("server_key", "current") {
+	case "current":
+		return false, true
+	case "pending":
+		if !usePendingKey(s) {
+			c.JSON(http.StatusConflict, gin.H{"error": "no server key rotation is staged"})
+			return false, false
+		}
+		return true, true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "server_key must be current or pending"})
+		return false, false
+	}
+}
+
+// activateServerKey makes a key pair the server's, clears any staged rotation, rewrites the interface's
+// configs and loads the new key into the running interface as WG_APPLY_MODE asks.
+func activateServerKey(c *gin.Context, cfg *config.Config, s db.Server, priv, pub string) (wireguard.ApplyResult, error) {
+	updates := map[string]any{"private_key": priv, "public_key": pub, "pending_private_key": nil, "pending_public_key": nil, "key_staged_at": nil}
+	if err := db.DB.Model(&db.Server{}).Where("uuid = ?", s.UUID).Updates(updates).Error; err != nil {
+		return wireguard.ApplyResult{}, fmt.Errorf("save server key failed: %v", err)
+	}
+	_ = db.RecordAudit(c.GetString("username"), "server.rotate_keys", "", fmt.Sprintf("%s: old public key %s replaced by %s", s.Interface, s.PublicKey, pub))
+
+	s.PrivateKey, s.PublicKey = priv, pub
+	s.PendingPrivateKey, s.PendingPublicKey, s.KeyStagedAt = nil, nil, nil
+	if err := wireguard.WriteInterfaceConfigs(cfg, s); err != nil {
+		return wireguard.ApplyResult{}, fmt.Errorf("generate configs failed: %v", err)
+	}
+	// syncconf loads the private key along with the peers
+	return wireguard.ApplyChanges(cfg, wireguard.Reload{Peers: true}), nil
+}
+
+type RotateServerKeysRequest struct {
+	// Staged keeps the current key active and only prepares the next one
+	Staged bool `json:"staged"`
+}
+
+// POST /api/v1/configs/server/:uuid/rotate-keys -> Replace the server key pair now, or stage the next one ({"staged":true})
+func RotateServerKeys(c *gin.Context) {
+	var req RotateServerKeysRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+	cfg, s, ok := serverOf(c)
+	if !ok {
+		return
+	}
+	if s.KeyStagedAt != nil {
+		// Configs with the staged key may already be out there; replacing it would strand them
+		c.JSON(http.StatusConflict, gin.H{"error": "a server key rotation is already staged; activate or discard it first"})
+		return
+	}
+	priv, pub, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("generate server key failed: %v", err)})
+		return
+	}
+
+	if req.Staged {
+		now := time.Now()
+		if err := db.DB.Model(&db.Server{}).Where("uuid = ?", s.UUID).Updates(map[string]any{"pending_private_key": priv, "pending_public_key": pub, "key_staged_at": now}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("save server key failed: %v", err)})
+			return
+		}
+		_ = db.RecordAudit(c.GetString("username"), "server.stage_keys", "", fmt.Sprintf("%s: staged public key %s", s.Interface, pub))
+		c.JSON(http.StatusOK, gin.H{"message": "server key rotation staged", "pending_public_key": pub, "staged_at": now})
+		return
+	}
+
+	applied, err := activateServerKey(c, cfg, s, priv, pub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "server key rotated", "public_key": pub}, applied))
+}
+
+// POST /api/v1/configs/server/:uuid/rotate-keys/activate -> Make the staged key pair the server's
+func ActivateServerKeys(c *gin.Context) {
+	cfg, s, ok := serverOf(c)
+	if !ok {
+		return
+	}
+	if s.PendingPrivateKey == nil || s.PendingPublicKey == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "no server key rotation is staged"})
+		return
+	}
+	applied, err := activateServerKey(c, cfg, s, *s.PendingPrivateKey, *s.PendingPublicKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, withApply(gin.H{"message": "staged server key activated", "public_key": *s.PendingPublicKey}, applied))
+}
+
+// DELETE /api/v1/configs/server/:uuid/rotate-keys -> Discard the staged key pair
+func DiscardServerKeys(c *gin.Context) {
+	_, s, ok := serverOf(c)
+	if !ok {
+		return
+	}
+	res := db.DB.Model(&db.Server{}).Where("uuid = ? AND key_staged_at IS NOT NULL", s.UUID).
+		Updates(map[string]any{"pending_private_key": nil, "pending_public_key": nil, "key_staged_at": nil})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("discard server key failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no server key rotation is staged"})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "server.discard_keys", "", s.Interface)
+	c.JSON(http.StatusOK, gin.H{"message": "staged server key discarded"})
+}
+
+// GET /api/v1/configs/server/:uuid/rotate-keys/configs -> Zip of every peer's wg-quick config with the staged key
+func StagedPeerConfigs(c *gin.Context) {
+	_, s, ok := serverOf(c)
+	if !ok {
+		return
+	}
+	if !usePendingKey(&s) {
+		c.JSON(http.StatusConflict, gin.H{"error": "no server key rotation is staged"})
+		return
+	}
+	var peers []db.Peer
+	if err := db.DB.Scopes(db.OnServer(s.UUID)).Preload("Group").Order("ipv4").Find(&peers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
+		return
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, p := range peers {
+		w, err := zw.Create(fmt.Sprintf("%s.conf", p.UUID))
+		if err == nil {
+			_, err = w.Write([]byte(wireguard.RenderPeerConfig(s, p)))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("build archive failed: %v", err)})
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("build archive failed: %v", err)})
+		return
+	}
+	// The configs carry the peers' private keys; keep them out of browser and proxy caches
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-staged-configs.zip\"", s.Interface))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
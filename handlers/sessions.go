@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GET /api/v1/sessions -> Connection sessions of all peers of the interface, newest first
+//
+// Query parameters:
+//   - peer: peer uuid
+//   - from, to: RFC 3339; only sessions overlapping the range
+//   - open: true for sessions still in progress, false for ended ones
+//   - limit, offset: page size (default 50, max 500) and offset
+func ListSessions(c *gin.Context) {
+	_, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	listSessions(c, c.Query("peer"), s.Interface)
+}
+
+// GET /api/v1/peers/:uuid/sessions -> Connection sessions of one peer, newest first (same query as /sessions)
+func PeerSessions(c *gin.Context) {
+	listSessions(c, c.Param("uuid"), "")
+}
+
+func listSessions(c *gin.Context, peerUUID, iface string) {
+	q := db.DB.Model(&db.PeerSession{}).
+		Select("peer_session.*, peer.name AS peer_name").
+		Joins("LEFT JOIN peer ON peer.uuid = peer_session.peer_uuid")
+	if peerUUID != "" {
+		q = q.Where("peer_session.peer_uuid = ?", peerUUID)
+	}
+	if iface != "" {
+		q = q.Where("peer_session.interface = ?", iface)
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC 3339"})
+			return
+		}
+		q = q.Where("peer_session.ended_at IS NULL OR peer_session.ended_at >= ?", t)
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC 3339"})
+			return
+		}
+		q = q.Where("peer_session.started_at < ?", t)
+	}
+	switch open := c.Query("open"); open {
+	case "":
+	case "true":
+		q = q.Where("peer_session.ended_at IS NULL")
+	case "false":
+		q = q.Where("peer_session.ended_at IS NOT NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "open must be true or false"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	var total int64
+	if err := q.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count sessions failed: %v", err)})
+		return
+	}
+	sessions := []db.PeerSession{}
+	if err := q.Order("peer_session.started_at DESC, peer_session.id DESC").Limit(limit).Offset(offset).Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query sessions failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "total": total, "limit": limit, "offset": offset})
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/auth"
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultShareMinutes = 24 * 60
+	maxShareMinutes     = 30 * 24 * 60
+)
+
+// POST /api/v1/configs/peer/:uuid/share -> Create a share link for the peer's config
+type CreateShareLinkRequest struct {
+	ExpiresInMinutes *int `json:"expires_in_minutes"`
+	MaxUses          *int `json:"max_uses"`
+}
+
+func CreateShareLink(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var req CreateShareLinkRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+	minutes := defaultShareMinutes
+	if req.ExpiresInMinutes != nil {
+		minutes = *req.ExpiresInMinutes
+	}
+	if minutes < 1 || minutes > maxShareMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in_minutes must be between 1 and %d", maxShareMinutes)})
+		return
+	}
+	maxUses := 1
+	if req.MaxUses != nil {
+		maxUses = *req.MaxUses
+	}
+	if maxUses < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must be at least 1"})
+		return
+	}
+	var p db.Peer
+	if err := db.DB.Where("uuid = ?", uuid).First(&p).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return
+	}
+
+	link := db.ShareLink{
+		PeerUUID:  p.UUID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Duration(minutes) * time.Minute),
+		MaxUses:   maxUses,
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create share link failed: %v", err)})
+		return
+	}
+	token, err := auth.GenerateShareToken(link.UUID, p.UUID, link.ExpiresAt)
+	if err != nil {
+		_ = db.DB.Delete(&db.ShareLink{}, "uuid = ?", link.UUID).Error
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "share.create", p.UUID, fmt.Sprintf("share link %s, %d use(s), expires %s", link.UUID, link.MaxUses, link.ExpiresAt.Format(time.RFC3339)))
+
+	// The token is only returned here; listing shows link metadata but never the token again
+	c.JSON(http.StatusOK, gin.H{
+		"link":   link,
+		"token":  token,
+		"url":    "/share/" + token,
+		"qr_url": "/share/" + token + "/qr",
+	})
+}
+
+// GET /api/v1/shares -> List outstanding share links (?peer=<uuid>, ?all=true to include spent ones)
+func GetShareLinks(c *gin.Context) {
+	q := db.DB.Order("created_at DESC")
+	if peer := c.Query("peer"); peer != "" {
+		q = q.Where("peer_uuid = ?", peer)
+	}
+	if c.Query("all") != "true" {
+		q = q.Where("revoked_at IS NULL AND expires_at > now() AND uses < max_uses")
+	}
+	var links []db.ShareLink
+	if err := q.Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query share links failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+// DELETE /api/v1/shares/:uuid -> Revoke a share link
+func RevokeShareLink(c *gin.Context) {
+	uuid := c.Param("uuid")
+	res := db.DB.Model(&db.ShareLink{}).Where("uuid = ? AND revoked_at IS NULL", uuid).Update("revoked_at", time.Now())
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("revoke share link failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found or already revoked"})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "share.revoke", "", "share link "+uuid)
+	c.JSON(http.StatusOK, gin.H{"message": "share link revoked"})
+}
+
+// GET /share/:token -> Public download of the shared peer config
+func ShareDownload(c *gin.Context) {
+	s, p, linkID, ok := loadShareToken(c, false)
+	if !ok {
+		return
+	}
+	var path string
+	ok = redeemShareLink(c, linkID, p.UUID, func() (err error) {
+		if path, err = wireguard.GeneratePeerConfig(config.LoadConfig(), s, p); err != nil {
+			return fmt.Errorf("generate peer config failed: %w", err)
+		}
+		return nil
+	})
+	if ok {
+		sendPeerConfig(c, p, path)
+	}
+}
+
+// GET /share/:token/qr -> Public QR code of the shared peer config (same query parameters as the panel endpoint)
+func ShareQR(c *gin.Context) {
+	params, ok := parseQRParams(c)
+	if !ok {
+		return
+	}
+	s, p, linkID, ok := loadShareToken(c, true)
+	if !ok {
+		return
+	}
+	var img []byte
+	var contentType string
+	ok = redeemShareLink(c, linkID, p.UUID, func() (err error) {
+		img, contentType, err = renderPeerQR(s, p, params)
+		return err
+	})
+	if ok {
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, contentType, img)
+	}
+}
+
+// loadShareToken validates the token and loads the peer and the ID of its link.
+// With needPrivateKey, bring-your-own-key peers are refused.
+func loadShareToken(c *gin.Context, needPrivateKey bool) (db.Server, db.Peer, string, bool) {
+	claims, err := auth.ValidateShareToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid or expired link"})
+		return db.Server{}, db.Peer{}, "", false
+	}
+	s, p, ok := loadServerAndPeer(c, claims.Subject)
+	if !ok {
+		return s, p, "", false
+	}
+	if needPrivateKey && p.PrivateKey == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "peer uses its own key pair; no QR code can be generated without its private key"})
+		return s, p, "", false
+	}
+	return s, p, claims.ID, true
+}
+
+// redeemShareLink runs render and consumes one use of the link in one transaction. The link row stays
+// locked while rendering, and a failed render rolls back, so only a delivered config costs a use.
+func redeemShareLink(c *gin.Context, linkID, peerUUID string, render func() error) bool {
+	var renderErr error
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var link db.ShareLink
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("uuid = ? AND peer_uuid = ? AND revoked_at IS NULL AND expires_at > now() AND uses < max_uses", linkID, peerUUID).
+			First(&link).Error
+		if err != nil {
+			return err
+		}
+		if renderErr = render(); renderErr != nil {
+			return renderErr
+		}
+		return tx.Model(&db.ShareLink{}).Where("uuid = ?", linkID).Update("uses", gorm.Expr("uses + 1")).Error
+	})
+	switch {
+	case renderErr != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": renderErr.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusGone, gin.H{"error": "link has been revoked, has expired or has been used up"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "share link lookup failed"})
+	default:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/netutil"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/gin-gonic/gin"
+)
+
+// SubnetUsage is how much of a server subnet the address allocator has handed out.
+type SubnetUsage struct {
+	Subnet    string  `json:"subnet"`
+	Used      int64   `json:"used"`
+	Capacity  int64   `json:"capacity"`
+	Available int64   `json:"available"`
+	Percent   float64 `json:"percent"`
+}
+
+// subnetUsage computes the capacity the allocator works with: every host address but the first (used by
+// the server) and, for IPv4, the broadcast address; IPv6 allocation gives up after netutil.MaxIPv6Allocations.
+func subnetUsage(subnet string, used int64) SubnetUsage {
+	u := SubnetUsage{Subnet: subnet, Used: used}
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return u
+	}
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if prefix.Addr().Is4() {
+		u.Capacity = int64(1)<<hostBits - 2
+	} else {
+		u.Capacity = netutil.MaxIPv6Allocations
+		if hostBits < 17 {
+			u.Capacity = min(u.Capacity, int64(1)<<hostBits-1)
+		}
+	}
+	if u.Capacity > 0 {
+		u.Available = max(u.Capacity-used, 0)
+		u.Percent = math.Round(float64(used)/float64(u.Capacity)*10000) / 100
+	}
+	return u
+}
+
+// GET /api/v1/summary -> Dashboard overview of one interface in one call (?top=5 peers by traffic this month, ?audit=10 recent audit entries)
+func Summary(c *gin.Context) {
+	top, err := strconv.Atoi(c.DefaultQuery("top", "5"))
+	if err != nil || top < 0 || top > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "top must be between 0 and 50"})
+		return
+	}
+	auditN, err := strconv.Atoi(c.DefaultQuery("audit", "10"))
+	if err != nil || auditN < 0 || auditN > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "audit must be between 0 and 100"})
+		return
+	}
+	cfg, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	var counts struct {
+		Total     int64 `json:"total"`
+		Enabled   int64 `json:"enabled"`
+		Disabled  int64 `json:"disabled"`
+		Expired   int64 `json:"expired"`
+		Suspended int64 `json:"suspended"`
+		Active    int64 `json:"active"`
+		Online    int64 `json:"online"`
+	}
+	err = db.DB.Model(&db.Peer{}).Scopes(db.OnServer(s.UUID)).Select(`count(*) AS total,
+		count(*) FILTER (WHERE enabled) AS enabled,
+		count(*) FILTER (WHERE NOT enabled) AS disabled,
+		count(*) FILTER (WHERE expires_at <= now()) AS expired,
+		count(*) FILTER (WHERE quota_exceeded_at IS NOT NULL) AS suspended,
+		count(*) FILTER (WHERE enabled AND (expires_at IS NULL OR expires_at > now()) AND quota_exceeded_at IS NULL) AS active`).
+		Scan(&counts).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count peers failed: %v", err)})
+		return
+	}
+
+	// Interface: the systemd unit and the kernel interface can disagree (e.g. a unit that failed to start)
+	svc := serviceName(cfg)
+	var state string
+	if cfg.Remote() {
+		st, _ := wireguard.RemoteStatus(cfg)
+		state = st.ServiceState
+	} else {
+		state, _ = runSystemctl("is-active", svc)
+	}
+	iface := gin.H{"name": cfg.WGInterface, "service": svc, "service_state": strings.TrimSpace(state), "up": false}
+	if cfg.Remote() {
+		iface["node"] = cfg.WGNode
+	}
+	if dump, err := wireguard.ShowDump(cfg); err == nil {
+		iface["up"] = true
+		iface["listen_port"] = dump.ListenPort
+		iface["peers_loaded"] = len(dump.Peers)
+		var keys []string
+		for _, p := range dump.Peers {
+			if p.Online(now) {
+				keys = append(keys, p.PublicKey)
+			}
+		}
+		if len(keys) > 0 {
+			if err := db.DB.Model(&db.Peer{}).Scopes(db.OnServer(s.UUID)).Where("public_key IN ?", keys).Count(&counts.Online).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count online peers failed: %v", err)})
+				return
+			}
+		}
+	}
+
+	var used struct {
+		V4 int64
+		V6 int64
+	}
+	err = db.DB.Model(&db.Peer{}).Scopes(db.OnServer(s.UUID)).
+		Select("count(*) FILTER (WHERE ipv4 <<= ?::cidr) AS v4, count(*) FILTER (WHERE ipv6 <<= ?::cidr) AS v6", s.SubnetV4, s.SubnetV6).
+		Scan(&used).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count addresses failed: %v", err)})
+		return
+	}
+	subnets := gin.H{"ipv4": subnetUsage(s.SubnetV4, used.V4)}
+	if s.EnableIPv6 {
+		subnets["ipv6"] = subnetUsage(s.SubnetV6, used.V6)
+	}
+
+	// Traffic from the daily rollups, which line up with UTC days and months
+	dayStart, _ := db.QuotaPeriodStart(db.QuotaDay, now)
+	monthStart, _ := db.QuotaPeriodStart(db.QuotaMonth, now)
+	var traffic struct {
+		TodayRx int64 `json:"today_rx_bytes"`
+		TodayTx int64 `json:"today_tx_bytes"`
+		MonthRx int64 `json:"month_rx_bytes"`
+		MonthTx int64 `json:"month_tx_bytes"`
+	}
+	err = db.DB.Model(&db.PeerUsage{}).
+		Select(`coalesce(sum(rx_bytes) FILTER (WHERE bucket >= ?), 0) AS today_rx,
+			coalesce(sum(tx_bytes) FILTER (WHERE bucket >= ?), 0) AS today_tx,
+			coalesce(sum(rx_bytes), 0) AS month_rx,
+			coalesce(sum(tx_bytes), 0) AS month_tx`, dayStart, dayStart).
+		Where("resolution = ? AND interface = ? AND bucket >= ?", db.UsageDay, cfg.WGInterface, monthStart).
+		Scan(&traffic).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query traffic failed: %v", err)})
+		return
+	}
+
+	type topPeer struct {
+		UUID    string  `json:"uuid"`
+		Name    *string `json:"name"`
+		RxBytes int64   `json:"rx_bytes"`
+		TxBytes int64   `json:"tx_bytes"`
+	}
+	topPeers := []topPeer{}
+	if top > 0 {
+		err = db.DB.Model(&db.PeerUsage{}).
+			Select("peer_usage.peer_uuid AS uuid, peer.name, sum(peer_usage.rx_bytes) AS rx_bytes, sum(peer_usage.tx_bytes) AS tx_bytes").
+			Joins("JOIN peer ON peer.uuid = peer_usage.peer_uuid").
+			Where("peer_usage.resolution = ? AND peer_usage.interface = ? AND peer_usage.bucket >= ?", db.UsageDay, cfg.WGInterface, monthStart).
+			Group("peer_usage.peer_uuid, peer.name").
+			Order("sum(peer_usage.rx_bytes + peer_usage.tx_bytes) DESC").
+			Limit(top).Scan(&topPeers).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query top peers failed: %v", err)})
+			return
+		}
+	}
+
+	audit := []db.AuditLog{}
+	if auditN > 0 {
+		if err := db.DB.Order("id DESC").Limit(auditN).Find(&audit).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query audit log failed: %v", err)})
+			return
+		}
+	}
+
+	var firing int64
+	_ = db.DB.Model(&db.Alert{}).Where("resolved_at IS NULL AND peer_uuid IN (SELECT uuid FROM peer WHERE server_uuid = ?)", s.UUID).Count(&firing).Error
+
+	c.JSON(http.StatusOK, gin.H{
+		"peers":         counts,
+		"interface":     iface,
+		"subnets":       subnets,
+		"traffic":       traffic,
+		"top_peers":     topPeers,
+		"audit":         audit,
+		"alerts_firing": firing,
+		"generated_at":  now,
+	})
+}
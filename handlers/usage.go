@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/stats"
+	"github.com/gin-gonic/gin"
+)
+
+// parseUsageRange reads ?from=&to= (RFC 3339, default the last 24 hours) and ?step= (raw, hour, day;
+// picked from the range when omitted), writing the error response itself when they are invalid.
+func parseUsageRange(c *gin.Context) (from, to time.Time, step string, ok bool) {
+	to = time.Now()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC 3339"})
+			return from, to, step, false
+		}
+		to = t
+	}
+	from = to.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC 3339"})
+			return from, to, step, false
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return from, to, step, false
+	}
+	step = c.DefaultQuery("step", stats.AutoStep(from, to))
+	if !stats.ValidStep(step) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "step must be raw, hour or day"})
+		return from, to, step, false
+	}
+	return from, to, step, true
+}
+
+func usageResponse(c *gin.Context, f stats.Filter, from, to time.Time, step string) {
+	points, err := stats.Series(f, from, to, step)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query usage failed: %v", err)})
+		return
+	}
+	rx, tx := stats.Total(points)
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "step": step, "series": points, "rx_bytes": rx, "tx_bytes": tx})
+}
+
+// GET /api/v1/peers/:uuid/usage -> Traffic history of one peer (?from=&to=&step=raw|hour|day)
+func PeerUsage(c *gin.Context) {
+	from, to, step, ok := parseUsageRange(c)
+	if !ok {
+		return
+	}
+	// History outlives its peer, so a deleted peer with recorded traffic is still found
+	uuid := c.Param("uuid")
+	var peers, rows int64
+	_ = db.DB.Model(&db.Peer{}).Where("uuid = ?", uuid).Count(&peers).Error
+	if peers == 0 {
+		_ = db.DB.Model(&db.PeerUsage{}).Where("peer_uuid = ?", uuid).Count(&rows).Error
+	}
+	if peers == 0 && rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return
+	}
+	usageResponse(c, stats.Filter{PeerUUID: uuid}, from, to, step)
+}
+
+// GET /api/v1/usage -> Traffic history of the whole interface (?from=&to=&step=raw|hour|day)
+func InterfaceUsage(c *gin.Context) {
+	from, to, step, ok := parseUsageRange(c)
+	if !ok {
+		return
+	}
+	_, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	usageResponse(c, stats.Filter{Interface: s.Interface}, from, to, step)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/webhooks"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// GET /api/v1/webhooks -> List webhooks (secrets are never returned)
+func GetWebhooks(c *gin.Context) {
+	var hooks []db.Webhook
+	if err := db.DB.Order("created_at").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query webhooks failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+// POST /api/v1/webhooks -> Create webhook
+// PUT /api/v1/webhooks/:uuid -> Update webhook (any subset of fields)
+type WebhookRequest struct {
+	Name *string `json:"name"`
+	URL  *string `json:"url"`
+	// Events are types ("peer.created") or families ("peer.*"); empty means every event
+	Events *[]string `json:"events"`
+	// Secret signs the payloads; empty generates a new random one, returned once in the response
+	Secret  *string `json:"secret"`
+	Enabled *bool   `json:"enabled"`
+}
+
+// apply validates the request and merges it into h. It returns the new secret when the request
+// asked for a generated one.
+func (req WebhookRequest) apply(h *db.Webhook) (string, error) {
+	if req.URL != nil {
+		u, err := parseHookURL(*req.URL)
+		if err != nil {
+			return "", err
+		}
+		h.URL = u
+	}
+	if req.Name != nil {
+		h.Name = strings.TrimSpace(*req.Name)
+	}
+	if h.Name == "" {
+		if u, err := url.Parse(h.URL); err == nil {
+			h.Name = u.Host
+		}
+	}
+	if req.Events != nil {
+		list := db.StringList{}
+		for _, e := range *req.Events {
+			e = strings.TrimSpace(e)
+			if !events.ValidFilter(e) {
+				return "", fmt.Errorf("unknown event %q", e)
+			}
+			list = append(list, e)
+		}
+		h.Events = list
+	}
+	if req.Enabled != nil {
+		h.Enabled = *req.Enabled
+	}
+	if req.Secret != nil {
+		if s := strings.TrimSpace(*req.Secret); s != "" {
+			h.Secret = s
+			return "", nil
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		h.Secret = hex.EncodeToString(b)
+		return h.Secret, nil
+	}
+	return "", nil
+}
+
+// parseHookURL validates the URL of a webhook or notifier.
+func parseHookURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("url must be an absolute http or https URL")
+	}
+	return u.String(), nil
+}
+
+func CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.Secret == nil {
+		req.Secret = new(string)
+	}
+	h := db.Webhook{Enabled: true, Events: db.StringList{}, CreatedAt: time.Now()}
+	secret, err := req.apply(&h)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}}}).Omit("uuid").Create(&h).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("create webhook failed: %v", err)})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "webhook.create", "", fmt.Sprintf("webhook %s to %s", h.UUID, h.URL))
+	resp := gin.H{"webhook": h}
+	if secret != "" {
+		resp["secret"] = secret
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func UpdateWebhook(c *gin.Context) {
+	uuid := c.Param("uuid")
+	var h db.Webhook
+	if err := db.DB.Where("uuid = ?", uuid).First(&h).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	secret, err := req.apply(&h)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = db.DB.Model(&db.Webhook{}).Where("uuid = ?", uuid).Updates(map[string]any{
+		"name":    h.Name,
+		"url":     h.URL,
+		"events":  h.Events,
+		"secret":  h.Secret,
+		"enabled": h.Enabled,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update webhook failed: %v", err)})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "webhook.update", "", fmt.Sprintf("webhook %s to %s", h.UUID, h.URL))
+	resp := gin.H{"webhook": h}
+	if secret != "" {
+		resp["secret"] = secret
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DELETE /api/v1/webhooks/:uuid -> Delete webhook and its delivery log
+func DeleteWebhook(c *gin.Context) {
+	uuid := c.Param("uuid")
+	res := db.DB.Delete(&db.Webhook{}, "uuid = ?", uuid)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("delete webhook failed: %v", res.Error)})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "webhook.delete", "", "webhook "+uuid)
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// GET /api/v1/webhooks/:uuid/deliveries -> Delivery log, newest first (?status=pending|delivered|failed, ?limit=50)
+func GetWebhookDeliveries(c *gin.Context) {
+	q := db.DB.Where("webhook_uuid = ?", c.Param("uuid")).Order("id DESC")
+	switch status := c.Query("status"); status {
+	case "":
+	case db.DeliveryPending, db.DeliveryDelivered, db.DeliveryFailed:
+		q = q.Where("status = ?", status)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+		return
+	}
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+	var deliveries []db.WebhookDelivery
+	if err := q.Limit(limit).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query webhook deliveries failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// POST /api/v1/webhooks/:uuid/test -> Send a webhook.test event now and return the outcome
+func TestWebhook(c *gin.Context) {
+	var h db.Webhook
+	if err := db.DB.Where("uuid = ?", c.Param("uuid")).First(&h).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	d, err := webhooks.SendTest(h, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("test delivery failed: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivered": d.Status == db.DeliveryDelivered, "delivery": d})
+}
@@ -3,12 +3,16 @@ package handlers
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
 	"github.com/gin-gonic/gin"
 )
 
@@ -24,11 +28,44 @@ func runSystemctl(args ...string) (string, error) {
 	return out.String(), nil
 }
 
-// Start: enable and start wg-quick@wg0
+// withApply adds the outcome of loading a change into the running interface to a response.
+func withApply(resp gin.H, r wireguard.ApplyResult) gin.H {
+	resp["applied"] = r.Applied
+	if r.Pending {
+		resp["apply_pending"] = true
+	}
+	if r.Error != "" {
+		log.Printf("[WG] %s", r.Error)
+		resp["apply_error"] = r.Error
+	}
+	return resp
+}
+
+// setDesiredUp asks the agent of a remote interface to start or stop it; the agent applies it on its next sync.
+func setDesiredUp(c *gin.Context, cfg *config.Config, s db.Server, up bool) {
+	if err := db.DB.Model(&db.Server{}).Where("uuid = ?", s.UUID).Update("desired_up", up).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("update server failed: %v", err)})
+		return
+	}
+	action := "stop"
+	if up {
+		action = "start"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("wireguard %s requested from node agent", action), "node": *s.NodeUUID, "pending": wireguard.InterfaceUp(cfg) != up})
+}
+
+// Start: enable and start wg-quick@<iface>
 func WGStart(c *gin.Context) {
-	cfg := config.LoadConfig()
+	cfg, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	if cfg.Remote() {
+		setDesiredUp(c, cfg, s, true)
+		return
+	}
 	confPath := filepath.Join(cfg.WGConfDir, fmt.Sprintf("%s.conf", cfg.WGInterface))
-	svc := fmt.Sprintf("%s-quick@%s", cfg.WGMode, cfg.WGInterface)
+	svc := serviceName(cfg)
 	// Ensure config exists
 	if _, err := os.Stat(confPath); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s not found in %s", filepath.Base(confPath), cfg.WGConfDir)})
@@ -39,37 +76,95 @@ func WGStart(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("systemctl enable failed: %v", err), "output": out, "service": svc})
 		return
 	}
+	wireguard.NoteInterface(cfg, wireguard.InterfaceUp(cfg))
 	c.JSON(http.StatusOK, gin.H{"message": "wireguard started", "output": out, "service": svc})
 }
 
-// Stop: disable and stop wg-quick@wg0
+// Stop: disable and stop wg-quick@<iface>
 func WGStop(c *gin.Context) {
-	cfg := config.LoadConfig()
-	svc := fmt.Sprintf("%s-quick@%s", cfg.WGMode, cfg.WGInterface)
+	cfg, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	if cfg.Remote() {
+		setDesiredUp(c, cfg, s, false)
+		return
+	}
+	svc := serviceName(cfg)
 	out, err := runSystemctl("--now", "disable", svc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("systemctl disable failed: %v", err), "output": out, "service": svc})
 		return
 	}
+	wireguard.NoteInterface(cfg, wireguard.InterfaceUp(cfg))
 	c.JSON(http.StatusOK, gin.H{"message": "wireguard stopped", "output": out, "service": svc})
 }
 
-// Restart: restart wg-quick@wg0
+// Restart: restart wg-quick@<iface>
 func WGRestart(c *gin.Context) {
-	cfg := config.LoadConfig()
-	svc := fmt.Sprintf("%s-quick@%s", cfg.WGMode, cfg.WGInterface)
+	cfg, _, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	if cfg.Remote() {
+		// The agent restarts the interface itself whenever its config changes
+		c.JSON(http.StatusConflict, gin.H{"error": "interface runs on a node agent, which restarts it on config changes; stop and start it instead"})
+		return
+	}
+	svc := serviceName(cfg)
 	out, err := runSystemctl("restart", svc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("systemctl restart failed: %v", err), "output": out, "service": svc})
 		return
 	}
+	// A restart that succeeded went down and up again
+	wireguard.NoteInterface(cfg, false)
+	wireguard.NoteInterface(cfg, wireguard.InterfaceUp(cfg))
 	c.JSON(http.StatusOK, gin.H{"message": "wireguard restarted", "output": out, "service": svc})
 }
 
-// Status: status wg-quick@wg0
+// POST /api/v1/wg/apply -> Load the config files into the running interface with wg syncconf, without a restart
+func WGApply(c *gin.Context) {
+	cfg, _, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	if cfg.Remote() {
+		c.JSON(http.StatusConflict, gin.H{"error": "interface runs on a node agent, which applies changes on its next sync"})
+		return
+	}
+	if !wireguard.InterfaceUp(cfg) {
+		c.JSON(http.StatusConflict, gin.H{"error": "interface is not running; starting it loads the config"})
+		return
+	}
+	r := wireguard.Apply(cfg, wireguard.ReloadAll)
+	if r.Error != "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("apply failed: %s", r.Error), "applied": false})
+		return
+	}
+	_ = db.RecordAudit(c.GetString("username"), "interface.apply", "", cfg.WGInterface)
+	c.JSON(http.StatusOK, gin.H{"message": "configuration applied", "applied": true})
+}
+
+// Status: status wg-quick@<iface>
 func WGStatus(c *gin.Context) {
-	cfg := config.LoadConfig()
-	svc := fmt.Sprintf("%s-quick@%s", cfg.WGMode, cfg.WGInterface)
+	cfg, _, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	svc := serviceName(cfg)
+	if cfg.Remote() {
+		st, ok := wireguard.RemoteStatus(cfg)
+		switch {
+		case !ok:
+			c.JSON(http.StatusOK, gin.H{"status": "error", "output": "", "error": "no recent report from node agent", "service": svc, "node": cfg.WGNode})
+		case !st.Up:
+			c.JSON(http.StatusOK, gin.H{"status": "error", "output": st.ServiceState, "error": st.Error, "service": svc, "node": cfg.WGNode, "reported_at": st.ReportedAt})
+		default:
+			c.JSON(http.StatusOK, gin.H{"status": "ok", "output": st.ServiceState, "service": svc, "node": cfg.WGNode, "reported_at": st.ReportedAt})
+		}
+		return
+	}
 	out, err := runSystemctl("status", svc)
 	if err != nil {
 		// status returns non-zero when inactive; still return output
@@ -79,10 +174,17 @@ func WGStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok", "output": out, "service": svc})
 }
 
-// Show: run `wg show` and return its output for detailed status
+// Show: run `wg show <iface>` and return its output for detailed status
 func WGShow(c *gin.Context) {
-	cfg := config.LoadConfig()
-	cmd := exec.Command(cfg.WGMode, "show")
+	cfg, _, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	if cfg.Remote() {
+		c.JSON(http.StatusConflict, gin.H{"error": "interface runs on a node agent; see /wg/peers for its reported state"})
+		return
+	}
+	cmd := exec.Command(cfg.WGMode, "show", cfg.WGInterface)
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
@@ -93,3 +195,77 @@ func WGShow(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"output": out.String()})
 }
+
+// PeerStatus joins a database peer with its runtime state on the interface.
+type PeerStatus struct {
+	UUID    string  `json:"UUID"`
+	Name    *string `json:"Name"`
+	IPv4    *string `json:"IPv4"`
+	IPv6    *string `json:"IPv6"`
+	Enabled bool    `json:"Enabled"`
+	// Loaded is false when the peer is missing from the running interface (disabled, expired, or not applied yet)
+	Loaded          bool       `json:"Loaded"`
+	Online          bool       `json:"Online"`
+	Endpoint        string     `json:"Endpoint"`
+	LatestHandshake *time.Time `json:"LatestHandshake"`
+	// HandshakeAge is in seconds; nil without a handshake
+	HandshakeAge *int64 `json:"HandshakeAge"`
+	RxBytes      int64  `json:"RxBytes"`
+	TxBytes      int64  `json:"TxBytes"`
+}
+
+// GET /api/v1/wg/peers -> Per-peer runtime status parsed from `wg show <iface> dump`
+func WGPeers(c *gin.Context) {
+	cfg, s, ok := interfaceOf(c)
+	if !ok {
+		return
+	}
+	var peers []db.Peer
+	if err := db.DB.Scopes(db.OnServer(s.UUID)).Order("ipv4").Find(&peers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("query peers failed: %v", err)})
+		return
+	}
+	// A down interface is a normal state: report every peer as not loaded instead of failing
+	dump, err := wireguard.ShowDump(cfg)
+	up := err == nil
+	runtime := map[string]wireguard.PeerDump{}
+	if up {
+		for _, p := range dump.Peers {
+			runtime[p.PublicKey] = p
+		}
+	}
+
+	now := time.Now()
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, p := range peers {
+		st := PeerStatus{UUID: p.UUID, Name: p.Name, IPv4: p.IPv4, IPv6: p.IPv6, Enabled: p.Enabled}
+		if rt, ok := runtime[p.PublicKey]; ok {
+			delete(runtime, p.PublicKey)
+			st.Loaded = true
+			st.Online = rt.Online(now)
+			st.Endpoint = rt.Endpoint
+			st.LatestHandshake = rt.LatestHandshake
+			st.RxBytes = rt.RxBytes
+			st.TxBytes = rt.TxBytes
+			if rt.LatestHandshake != nil {
+				age := int64(now.Sub(*rt.LatestHandshake).Seconds())
+				st.HandshakeAge = &age
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	// Peers on the interface that the database does not know about, e.g. added by hand with `wg set`
+	unknown := make([]wireguard.PeerDump, 0, len(runtime))
+	for _, rt := range runtime {
+		unknown = append(unknown, rt)
+	}
+
+	resp := gin.H{"up": up, "interface": cfg.WGInterface, "peers": statuses, "unknown": unknown, "online_window_seconds": int(wireguard.OnlineWindow.Seconds())}
+	if up {
+		resp["listen_port"] = dump.ListenPort
+		resp["public_key"] = dump.PublicKey
+	} else {
+		resp["error"] = err.Error()
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,61 @@
+package importer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"gorm.io/gorm"
+)
+
+// Run implements the `import` subcommand. Without -commit it only prints the plan.
+func Run(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	from := fs.String("from", "", "source tool: wg-easy or ngoduykhanh")
+	path := fs.String("path", "", "wg-easy wg0.json file, or ngoduykhanh db directory")
+	iface := fs.String("interface", cfg.WGInterface, "interface to import the peers into")
+	adopt := fs.Bool("adopt-server", false, "take over the source server key pair, port and IPv4 subnet")
+	commit := fs.Bool("commit", false, "write the import to the database (default is a dry run)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *path == "" {
+		fs.Usage()
+		return fmt.Errorf("-from and -path are required")
+	}
+
+	src, err := Load(*from, *path)
+	if err != nil {
+		return err
+	}
+
+	s, err := db.ServerByInterface(*iface)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("interface %s not found", *iface)
+	}
+	if err != nil {
+		return fmt.Errorf("query server failed: %w", err)
+	}
+	// Keys and addresses are unique across interfaces, so every existing peer counts as taken
+	var peers []db.Peer
+	if err := db.DB.Find(&peers).Error; err != nil {
+		return fmt.Errorf("query peers failed: %w", err)
+	}
+
+	rep := Plan(src, s, peers, Options{AdoptServer: *adopt})
+	if !*commit {
+		rep.Print(os.Stdout)
+		fmt.Println("Dry run only; re-run with -commit to import.")
+		return nil
+	}
+	if err := Commit(cfg, s, rep); err != nil {
+		rep.Print(os.Stdout)
+		return err
+	}
+	rep.Print(os.Stdout)
+	fmt.Println("Import committed; restart the WireGuard service to load the new peers.")
+	return nil
+}
@@ -0,0 +1,310 @@
+// Package importer migrates peers from other WireGuard management tools into this project's database.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"text/tabwriter"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ToolWGEasy      = "wg-easy"
+	ToolNgoduykhanh = "ngoduykhanh"
+)
+
+// Source is the tool-independent view of another tool's on-disk state.
+type Source struct {
+	Tool             string
+	ServerPrivateKey string
+	ServerPublicKey  string
+	ListenPort       int    // 0 when the source does not record it
+	SubnetV4         string // empty when the source does not record it
+	Clients          []Client
+}
+
+// Client is a single peer as found in the source.
+type Client struct {
+	SourceID     string
+	Name         string
+	IPv4         string // bare address, no prefix length
+	IPv6         string
+	PrivateKey   string
+	PublicKey    string
+	PresharedKey string
+	Enabled      bool
+}
+
+// Load reads the state of the given tool from path.
+func Load(tool, path string) (*Source, error) {
+	switch tool {
+	case ToolWGEasy:
+		return LoadWGEasy(path)
+	case ToolNgoduykhanh:
+		return LoadNgoduykhanh(path)
+	default:
+		return nil, fmt.Errorf("unknown source %q (expected %s or %s)", tool, ToolWGEasy, ToolNgoduykhanh)
+	}
+}
+
+type Options struct {
+	// AdoptServer takes over the source server's key pair, listen port and IPv4 subnet,
+	// so that existing client configurations keep working without being redistributed.
+	AdoptServer bool
+}
+
+const (
+	ActionCreate   = "create"
+	ActionReassign = "reassign"
+	ActionSkip     = "skip"
+)
+
+// Entry describes what will happen (or happened) to one source client.
+type Entry struct {
+	SourceID string
+	Name     string
+	Action   string
+	IPv4     string // planned address; "auto" when left to the database allocator
+	Reason   string
+	peer     *db.Peer
+}
+
+// ServerChange lists the server fields that AdoptServer will overwrite.
+type ServerChange struct {
+	PrivateKey string
+	PublicKey  string
+	Port       int
+	SubnetV4   string
+}
+
+type Report struct {
+	Tool     string
+	Server   ServerChange
+	Entries  []Entry
+	Problems []string // blocking problems; Commit refuses to run while any are present
+}
+
+// Plan maps the source onto the current server without touching the database.
+func Plan(src *Source, s db.Server, existing []db.Peer, opts Options) *Report {
+	rep := &Report{Tool: src.Tool}
+
+	// Private keys are checked against their public keys with `wg pubkey`; if it cannot run, that blocks the
+	// import once instead of skipping every client
+	deriveFailed := false
+	pairMatches := func(priv, pub string) bool {
+		derived, err := wireguard.DerivePublicKey(priv)
+		if err != nil {
+			if !deriveFailed {
+				rep.Problems = append(rep.Problems, fmt.Sprintf("cannot check key pairs: %v", err))
+				deriveFailed = true
+			}
+			return true
+		}
+		return derived == pub
+	}
+
+	subnetV4 := s.SubnetV4
+	if opts.AdoptServer {
+		if wireguard.ValidKey(src.ServerPrivateKey) && wireguard.ValidKey(src.ServerPublicKey) {
+			if !pairMatches(src.ServerPrivateKey, src.ServerPublicKey) {
+				rep.Problems = append(rep.Problems, "source server private key does not match its public key")
+			} else if src.ServerPublicKey != s.PublicKey {
+				rep.Server.PrivateKey = src.ServerPrivateKey
+				rep.Server.PublicKey = src.ServerPublicKey
+			}
+		} else {
+			rep.Problems = append(rep.Problems, "source has no valid server key pair to adopt")
+		}
+		if src.ListenPort > 0 && src.ListenPort != s.Port {
+			rep.Server.Port = src.ListenPort
+		}
+		if src.SubnetV4 != "" && src.SubnetV4 != s.SubnetV4 {
+			if len(existing) > 0 {
+				rep.Problems = append(rep.Problems, fmt.Sprintf("cannot adopt subnet %s: %d peers already use %s", src.SubnetV4, len(existing), s.SubnetV4))
+			} else {
+				rep.Server.SubnetV4 = src.SubnetV4
+				subnetV4 = src.SubnetV4
+			}
+		}
+	}
+
+	prefixV4, _ := netip.ParsePrefix(subnetV4)
+	prefixV6, _ := netip.ParsePrefix(s.SubnetV6)
+
+	usedAddrs := map[netip.Addr]bool{}
+	usedKeys := map[string]bool{}
+	for _, p := range existing {
+		for _, a := range []*string{p.IPv4, p.IPv6} {
+			if a == nil {
+				continue
+			}
+			if prefix, err := netip.ParsePrefix(*a); err == nil {
+				usedAddrs[prefix.Addr()] = true
+			}
+		}
+		usedKeys[p.PublicKey] = true
+	}
+
+	for _, cl := range src.Clients {
+		e := Entry{SourceID: cl.SourceID, Name: cl.Name, Action: ActionCreate}
+		switch {
+		case !wireguard.ValidKey(cl.PublicKey):
+			e.Action, e.Reason = ActionSkip, "invalid public key"
+		case usedKeys[cl.PublicKey]:
+			e.Action, e.Reason = ActionSkip, "public key already present"
+		case cl.PrivateKey != "" && !wireguard.ValidKey(cl.PrivateKey):
+			e.Action, e.Reason = ActionSkip, "invalid private key"
+		case cl.PrivateKey != "" && !pairMatches(cl.PrivateKey, cl.PublicKey):
+			e.Action, e.Reason = ActionSkip, "private key does not match public key"
+		case cl.PresharedKey != "" && !wireguard.ValidKey(cl.PresharedKey):
+			e.Action, e.Reason = ActionSkip, "invalid preshared key"
+		}
+		if e.Action == ActionSkip {
+			rep.Entries = append(rep.Entries, e)
+			continue
+		}
+		usedKeys[cl.PublicKey] = true
+
+		// Clients that only ever registered a public key become bring-your-own-key peers
+		p := &db.Peer{PublicKey: cl.PublicKey, Enabled: cl.Enabled}
+		if cl.PrivateKey != "" {
+			priv := cl.PrivateKey
+			p.PrivateKey = &priv
+		}
+		if cl.Name != "" {
+			name := cl.Name
+			p.Name = &name
+		}
+		if cl.PresharedKey != "" {
+			psk := cl.PresharedKey
+			p.PresharedKey = &psk
+		}
+
+		e.IPv4 = "auto"
+		if addr, err := netip.ParseAddr(cl.IPv4); err != nil || !addr.Is4() {
+			e.Action, e.Reason = ActionReassign, "no IPv4 address in source"
+		} else if reason := checkHost(addr, prefixV4, usedAddrs); reason != "" {
+			e.Action, e.Reason = ActionReassign, fmt.Sprintf("%s %s", addr, reason)
+		} else {
+			v4 := netip.PrefixFrom(addr, 32).String()
+			p.IPv4 = &v4
+			e.IPv4 = addr.String()
+			usedAddrs[addr] = true
+		}
+		// IPv6 is kept when it fits; otherwise the allocator picks one silently, as for new peers
+		if addr, err := netip.ParseAddr(cl.IPv6); err == nil && addr.Is6() && checkHost(addr, prefixV6, usedAddrs) == "" {
+			v6 := netip.PrefixFrom(addr, 128).String()
+			p.IPv6 = &v6
+			usedAddrs[addr] = true
+		}
+
+		e.peer = p
+		rep.Entries = append(rep.Entries, e)
+	}
+	return rep
+}
+
+// checkHost returns why addr cannot be used as-is inside prefix, or "" if it can.
+func checkHost(addr netip.Addr, prefix netip.Prefix, used map[netip.Addr]bool) string {
+	if !prefix.IsValid() || !prefix.Contains(addr) {
+		return "is outside " + prefix.String()
+	}
+	if addr == prefix.Masked().Addr() {
+		return "is the network address"
+	}
+	if addr.Is4() && prefix.Bits() < 31 && addr == lastAddr(prefix) {
+		return "is the broadcast address"
+	}
+	if used[addr] {
+		return "is already in use"
+	}
+	return ""
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Masked().Addr().As4()
+	host := uint32(1)<<(32-prefix.Bits()) - 1
+	v := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	v |= host
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+// Commit applies the plan in a single transaction and regenerates all configuration files.
+// Entries are updated in place with the addresses actually assigned.
+func Commit(cfg *config.Config, s db.Server, rep *Report) error {
+	if len(rep.Problems) > 0 {
+		return fmt.Errorf("plan has %d blocking problems", len(rep.Problems))
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{}
+		if rep.Server.PublicKey != "" {
+			updates["private_key"] = rep.Server.PrivateKey
+			updates["public_key"] = rep.Server.PublicKey
+		}
+		if rep.Server.Port != 0 {
+			updates["port"] = rep.Server.Port
+		}
+		if rep.Server.SubnetV4 != "" {
+			updates["subnet_v4"] = rep.Server.SubnetV4
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&db.Server{}).Where("uuid = ?", s.UUID).Updates(updates).Error; err != nil {
+				return fmt.Errorf("update server: %w", err)
+			}
+		}
+		for i := range rep.Entries {
+			e := &rep.Entries[i]
+			if e.peer == nil {
+				continue
+			}
+			e.peer.ServerUUID = s.UUID
+			if err := tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "uuid"}, {Name: "ipv4"}, {Name: "ipv6"}}}).Omit("uuid").Create(e.peer).Error; err != nil {
+				return fmt.Errorf("create peer %s: %w", e.SourceID, err)
+			}
+			if e.peer.IPv4 != nil {
+				if prefix, err := netip.ParsePrefix(*e.peer.IPv4); err == nil {
+					e.IPv4 = prefix.Addr().String()
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return wireguard.WriteAllConfigs(cfg)
+}
+
+// Print writes a human-readable summary of the report.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Source: %s\n", r.Tool)
+	if r.Server.PublicKey != "" {
+		fmt.Fprintf(w, "Server: key pair will be replaced (public key %s)\n", r.Server.PublicKey)
+	}
+	if r.Server.Port != 0 {
+		fmt.Fprintf(w, "Server: listen port will change to %d\n", r.Server.Port)
+	}
+	if r.Server.SubnetV4 != "" {
+		fmt.Fprintf(w, "Server: IPv4 subnet will change to %s\n", r.Server.SubnetV4)
+	}
+
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE ID\tNAME\tACTION\tIPV4\tNOTE")
+	for _, e := range r.Entries {
+		counts[e.Action]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.SourceID, e.Name, e.Action, e.IPv4, e.Reason)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d to create, %d with new addresses, %d skipped\n", counts[ActionCreate], counts[ActionReassign], counts[ActionSkip])
+
+	for _, p := range r.Problems {
+		fmt.Fprintf(w, "PROBLEM: %s\n", p)
+	}
+}
@@ -0,0 +1,118 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ngoduykhanh/wireguard-ui stores one JSON document per object under its db directory:
+//
+//	db/server/keypair.json     {"private_key", "public_key"}
+//	db/server/interfaces.json  {"addresses": [...], "listen_port"}
+//	db/clients/<id>.json       one file per client
+type ngoServerKeypair struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+type ngoServerInterface struct {
+	Addresses  []string `json:"addresses"`
+	ListenPort int      `json:"listen_port"`
+}
+
+type ngoClient struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	PrivateKey   string   `json:"private_key"`
+	PublicKey    string   `json:"public_key"`
+	PresharedKey string   `json:"preshared_key"`
+	AllocatedIPs []string `json:"allocated_ips"`
+	Enabled      bool     `json:"enabled"`
+}
+
+// LoadNgoduykhanh reads the JSON db directory of ngoduykhanh/wireguard-ui.
+// dir may point either at the db directory itself or at its parent.
+func LoadNgoduykhanh(dir string) (*Source, error) {
+	if _, err := os.Stat(filepath.Join(dir, "clients")); err != nil {
+		if _, err2 := os.Stat(filepath.Join(dir, "db", "clients")); err2 != nil {
+			return nil, fmt.Errorf("no clients directory found in %s", dir)
+		}
+		dir = filepath.Join(dir, "db")
+	}
+
+	src := &Source{Tool: ToolNgoduykhanh}
+
+	var kp ngoServerKeypair
+	if err := readJSON(filepath.Join(dir, "server", "keypair.json"), &kp); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	src.ServerPrivateKey = kp.PrivateKey
+	src.ServerPublicKey = kp.PublicKey
+
+	var iface ngoServerInterface
+	if err := readJSON(filepath.Join(dir, "server", "interfaces.json"), &iface); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	src.ListenPort = iface.ListenPort
+	for _, a := range iface.Addresses {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(a))
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4() && src.SubnetV4 == "" {
+			src.SubnetV4 = prefix.Masked().String()
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "clients", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		var cl ngoClient
+		if err := readJSON(f, &cl); err != nil {
+			return nil, err
+		}
+		if cl.ID == "" {
+			cl.ID = strings.TrimSuffix(filepath.Base(f), ".json")
+		}
+		client := Client{
+			SourceID:     cl.ID,
+			Name:         cl.Name,
+			PrivateKey:   cl.PrivateKey,
+			PublicKey:    cl.PublicKey,
+			PresharedKey: cl.PresharedKey,
+			Enabled:      cl.Enabled,
+		}
+		for _, a := range cl.AllocatedIPs {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(a))
+			if err != nil {
+				continue
+			}
+			if prefix.Addr().Is4() && client.IPv4 == "" {
+				client.IPv4 = prefix.Addr().String()
+			} else if prefix.Addr().Is6() && client.IPv6 == "" {
+				client.IPv6 = prefix.Addr().String()
+			}
+		}
+		src.Clients = append(src.Clients, client)
+	}
+	return src, nil
+}
+
+func readJSON(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
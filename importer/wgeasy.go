@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+)
+
+// wg-easy keeps its whole state in a single wg0.json:
+//
+//	{"server": {"privateKey", "publicKey", "address"}, "clients": {"<id>": {...}}}
+//
+// The listen port is not stored in the file (it comes from WG_PORT), so it is left unset.
+type wgEasyState struct {
+	Server struct {
+		PrivateKey string `json:"privateKey"`
+		PublicKey  string `json:"publicKey"`
+		Address    string `json:"address"`
+	} `json:"server"`
+	Clients map[string]struct {
+		ID           string `json:"id"`
+		Name         string `json:"name"`
+		Address      string `json:"address"`
+		PrivateKey   string `json:"privateKey"`
+		PublicKey    string `json:"publicKey"`
+		PreSharedKey string `json:"preSharedKey"`
+		Enabled      *bool  `json:"enabled"`
+	} `json:"clients"`
+}
+
+// LoadWGEasy reads a wg-easy wg0.json file.
+func LoadWGEasy(path string) (*Source, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st wgEasyState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	src := &Source{
+		Tool:             ToolWGEasy,
+		ServerPrivateKey: st.Server.PrivateKey,
+		ServerPublicKey:  st.Server.PublicKey,
+	}
+	// wg-easy always hands out addresses from a /24 around the server address (WG_DEFAULT_ADDRESS)
+	if addr, err := netip.ParseAddr(st.Server.Address); err == nil && addr.Is4() {
+		prefix, _ := addr.Prefix(24)
+		src.SubnetV4 = prefix.String()
+	}
+
+	for id, cl := range st.Clients {
+		if cl.ID != "" {
+			id = cl.ID
+		}
+		enabled := true
+		if cl.Enabled != nil {
+			enabled = *cl.Enabled
+		}
+		src.Clients = append(src.Clients, Client{
+			SourceID:     id,
+			Name:         cl.Name,
+			IPv4:         cl.Address,
+			PrivateKey:   cl.PrivateKey,
+			PublicKey:    cl.PublicKey,
+			PresharedKey: cl.PreSharedKey,
+			Enabled:      enabled,
+		})
+	}
+	// Map iteration order is random; keep reports stable between dry run and commit
+	sort.Slice(src.Clients, func(i, j int) bool { return src.Clients[i].SourceID < src.Clients[j].SourceID })
+	return src, nil
+}
@@ -1,7 +1,7 @@
 -- Initialize PostgreSQL extensions
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
--- server table: only one row, uuid primary key, insert fixed uuid at initialization, deletion not allowed, only updates allowed
+-- server table: one row per managed interface (see the interface column below), uuid primary key; the first row is inserted at initialization
 CREATE TABLE IF NOT EXISTS server (
     uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     public_ip INET NOT NULL,
@@ -13,32 +13,13 @@ CREATE TABLE IF NOT EXISTS server (
     public_key TEXT NOT NULL
 );
 
--- Prevent multiple insertions & prohibit deletion of server row
-CREATE OR REPLACE FUNCTION enforce_singleton_server()
-RETURNS trigger AS $$
-BEGIN
-    IF TG_OP = 'INSERT' THEN
-        IF (SELECT COUNT(*) FROM server) >= 1 THEN
-            RAISE EXCEPTION 'Server table allows only one row';
-        END IF;
-        RETURN NEW;
-    ELSIF TG_OP = 'DELETE' THEN
-        RAISE EXCEPTION 'Deletion from server is not allowed';
-        RETURN OLD;
-    END IF;
-    RETURN NEW;
-END;
-$$ LANGUAGE plpgsql;
-
+-- One server row per managed interface; the single-row guard of earlier releases is removed.
+-- A server cannot be deleted while peers reference it (peer.server_uuid below).
 DROP TRIGGER IF EXISTS server_insert_guard ON server;
-CREATE TRIGGER server_insert_guard
-BEFORE INSERT ON server
-FOR EACH ROW EXECUTE FUNCTION enforce_singleton_server();
-
 DROP TRIGGER IF EXISTS server_delete_guard ON server;
-CREATE TRIGGER server_delete_guard
-BEFORE DELETE ON server
-FOR EACH ROW EXECUTE FUNCTION enforce_singleton_server();
+DROP FUNCTION IF EXISTS enforce_singleton_server();
+-- interface is NULL only on the row of an upgraded single-interface database; the panel names it WG_INTERFACE at startup
+ALTER TABLE server ADD COLUMN IF NOT EXISTS interface TEXT UNIQUE;
 
 -- peer table: uuid primary key, IPv4(/32), IPv6(/128) automatically assigned; only name field can be updated; entire row can be deleted
 CREATE TABLE IF NOT EXISTS peer (
@@ -50,8 +31,42 @@ CREATE TABLE IF NOT EXISTS peer (
     name TEXT
 );
 
--- Calculate the next available IPv4 (/32)
-CREATE OR REPLACE FUNCTION get_next_free_ipv4()
+-- Columns added after the initial release; ADD COLUMN IF NOT EXISTS keeps this script re-runnable on existing databases
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS preshared_key TEXT;
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
+-- Bring-your-own-key peers only supply a public key; no private key is stored for them
+ALTER TABLE peer ALTER COLUMN private_key DROP NOT NULL;
+-- A public key identifies exactly one peer; the index also settles concurrent requests adding the same key
+CREATE UNIQUE INDEX IF NOT EXISTS peer_public_key_idx ON peer (public_key);
+
+-- peer_group table: group-level client defaults that member peers inherit unless they override them
+CREATE TABLE IF NOT EXISTS peer_group (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    allowed_ips TEXT,
+    dns TEXT,
+    persistent_keepalive INTEGER CHECK (persistent_keepalive BETWEEN 0 AND 65535),
+    mtu INTEGER CHECK (mtu BETWEEN 1280 AND 9000),
+    expiry_days INTEGER CHECK (expiry_days > 0)
+);
+
+-- Per-peer overrides; NULL means "inherit from the group, or use the built-in default"
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS group_uuid UUID REFERENCES peer_group(uuid) ON DELETE SET NULL;
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS allowed_ips TEXT;
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS dns TEXT;
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS persistent_keepalive INTEGER CHECK (persistent_keepalive BETWEEN 0 AND 65535);
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS mtu INTEGER CHECK (mtu BETWEEN 1280 AND 9000);
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS peer_group_uuid_idx ON peer (group_uuid);
+
+-- Listing support: creation time for sorting, free-form tags (JSON array of strings) for filtering
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';
+CREATE INDEX IF NOT EXISTS peer_tags_idx ON peer USING GIN (tags);
+
+-- Calculate the next available IPv4 (/32) in the subnet of a server
+DROP FUNCTION IF EXISTS get_next_free_ipv4();
+CREATE OR REPLACE FUNCTION get_next_free_ipv4(srv UUID)
 RETURNS CIDR AS $$
 DECLARE
     subnet_v4 CIDR;
@@ -60,7 +75,7 @@ DECLARE
     current_ip INET;
     used_ip CIDR;
 BEGIN
-    SELECT s.subnet_v4 INTO subnet_v4 FROM server s LIMIT 1;
+    SELECT s.subnet_v4 INTO subnet_v4 FROM server s WHERE s.uuid = srv;
     IF NOT FOUND THEN
         RAISE EXCEPTION 'Server subnet_v4 not configured';
     END IF;
@@ -86,8 +101,9 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
--- Calculate the next available IPv6 (/128)
-CREATE OR REPLACE FUNCTION get_next_free_ipv6()
+-- Calculate the next available IPv6 (/128) in the subnet of a server
+DROP FUNCTION IF EXISTS get_next_free_ipv6();
+CREATE OR REPLACE FUNCTION get_next_free_ipv6(srv UUID)
 RETURNS CIDR AS $$
 DECLARE
     subnet_v6 CIDR;
@@ -95,7 +111,7 @@ DECLARE
     used_ip CIDR;
     attempts INTEGER := 0;
 BEGIN
-    SELECT s.subnet_v6 INTO subnet_v6 FROM server s LIMIT 1;
+    SELECT s.subnet_v6 INTO subnet_v6 FROM server s WHERE s.uuid = srv;
     IF NOT FOUND THEN
         RAISE EXCEPTION 'Server subnet_v6 not configured';
     END IF;
@@ -118,15 +134,19 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
--- Before insert trigger: automatically assign IPv4/IPv6
+-- Before insert trigger: automatically assign IPv4/IPv6 from the peer's server; a peer inserted without
+-- a server belongs to the only one, if there is exactly one
 CREATE OR REPLACE FUNCTION peer_before_insert()
 RETURNS trigger AS $$
 BEGIN
+    IF NEW.server_uuid IS NULL AND (SELECT COUNT(*) FROM server) = 1 THEN
+        SELECT s.uuid INTO NEW.server_uuid FROM server s;
+    END IF;
     IF NEW.ipv4 IS NULL THEN
-        NEW.ipv4 := get_next_free_ipv4();
+        NEW.ipv4 := get_next_free_ipv4(NEW.server_uuid);
     END IF;
     IF NEW.ipv6 IS NULL THEN
-        NEW.ipv6 := get_next_free_ipv6();
+        NEW.ipv6 := get_next_free_ipv6(NEW.server_uuid);
     END IF;
     RETURN NEW;
 END;
@@ -137,16 +157,22 @@ CREATE TRIGGER peer_auto_assign
 BEFORE INSERT ON peer
 FOR EACH ROW EXECUTE FUNCTION peer_before_insert();
 
--- Before update trigger: only allow updating the name field
-CREATE OR REPLACE FUNCTION peer_before_update_only_name()
+-- Before update trigger: identity and server are fixed; addresses only change when a subnet change renumbers the peers,
+-- in a transaction that sets wgui.renumber; keys only change through key rotation, which sets wgui.rotate
+CREATE OR REPLACE FUNCTION peer_before_update_guard()
 RETURNS trigger AS $$
 BEGIN
-    IF NEW.uuid <> OLD.uuid OR
-       NEW.ipv4 <> OLD.ipv4 OR
-       NEW.ipv6 <> OLD.ipv6 OR
-       NEW.private_key <> OLD.private_key OR
-       NEW.public_key <> OLD.public_key THEN
-        RAISE EXCEPTION 'Only the name field can be updated for peer records';
+    IF NEW.uuid <> OLD.uuid OR NEW.server_uuid <> OLD.server_uuid THEN
+        RAISE EXCEPTION 'The uuid and server of peer records cannot be updated';
+    END IF;
+    IF (NEW.ipv4 IS DISTINCT FROM OLD.ipv4 OR NEW.ipv6 IS DISTINCT FROM OLD.ipv6) AND
+       coalesce(current_setting('wgui.renumber', true), '') <> 'on' THEN
+        RAISE EXCEPTION 'The addresses of peer records can only change by renumbering the server subnet';
+    END IF;
+    IF (NEW.public_key IS DISTINCT FROM OLD.public_key OR NEW.private_key IS DISTINCT FROM OLD.private_key OR
+        NEW.preshared_key IS DISTINCT FROM OLD.preshared_key) AND
+       coalesce(current_setting('wgui.rotate', true), '') <> 'on' THEN
+        RAISE EXCEPTION 'The keys of peer records can only change by key rotation';
     END IF;
     RETURN NEW;
 END;
@@ -155,7 +181,224 @@ $$ LANGUAGE plpgsql;
 DROP TRIGGER IF EXISTS peer_update_guard ON peer;
 CREATE TRIGGER peer_update_guard
 BEFORE UPDATE ON peer
-FOR EACH ROW EXECUTE FUNCTION peer_before_update_only_name();
+FOR EACH ROW EXECUTE FUNCTION peer_before_update_guard();
+DROP FUNCTION IF EXISTS peer_before_update_only_name();
+
+-- audit_log table: append-only record of security-relevant changes (e.g. replaced peer public keys)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor TEXT,
+    action TEXT NOT NULL,
+    peer_uuid UUID,
+    detail TEXT
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+-- share_link table: expiring, use-limited links that serve one peer's config without panel credentials
+CREATE TABLE IF NOT EXISTS share_link (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    peer_uuid UUID NOT NULL REFERENCES peer(uuid) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 1 CHECK (max_uses > 0),
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS share_link_peer_uuid_idx ON share_link (peer_uuid);
+
+-- Email delivery of peer configs: recipient address on the peer, one row per send attempt
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS email TEXT;
+CREATE TABLE IF NOT EXISTS email_delivery (
+    id BIGSERIAL PRIMARY KEY,
+    peer_uuid UUID NOT NULL REFERENCES peer(uuid) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'failed')),
+    error TEXT
+);
+CREATE INDEX IF NOT EXISTS email_delivery_peer_uuid_idx ON email_delivery (peer_uuid, created_at);
+
+-- Firewall: isolation and the fallback for unmatched traffic, inherited like the client settings (NULL = inherit)
+ALTER TABLE peer_group ADD COLUMN IF NOT EXISTS isolated BOOLEAN;
+ALTER TABLE peer_group ADD COLUMN IF NOT EXISTS acl_default TEXT CHECK (acl_default IN ('accept', 'drop'));
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS isolated BOOLEAN;
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS acl_default TEXT CHECK (acl_default IN ('accept', 'drop'));
+
+-- acl_rule table: ordered forwarding rules for traffic sent by one peer or by every member of a group
+CREATE TABLE IF NOT EXISTS acl_rule (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    peer_uuid UUID REFERENCES peer(uuid) ON DELETE CASCADE,
+    group_uuid UUID REFERENCES peer_group(uuid) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    action TEXT NOT NULL CHECK (action IN ('accept', 'drop')),
+    destination CIDR,
+    protocol TEXT NOT NULL DEFAULT 'any' CHECK (protocol IN ('any', 'tcp', 'udp', 'icmp')),
+    ports TEXT CHECK (ports IS NULL OR protocol IN ('tcp', 'udp')),
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ((peer_uuid IS NULL) <> (group_uuid IS NULL))
+);
+CREATE INDEX IF NOT EXISTS acl_rule_peer_uuid_idx ON acl_rule (peer_uuid);
+CREATE INDEX IF NOT EXISTS acl_rule_group_uuid_idx ON acl_rule (group_uuid);
+
+-- Bandwidth shaping: per-peer rate limits in kbit/s, enforced with tc; NULL means unlimited
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS rate_up_kbit INTEGER CHECK (rate_up_kbit > 0);
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS rate_down_kbit INTEGER CHECK (rate_down_kbit > 0);
+
+-- Traffic accounting: last transfer counters per peer, so that restarts neither lose nor double count traffic
+CREATE TABLE IF NOT EXISTS peer_counter (
+    peer_uuid UUID PRIMARY KEY REFERENCES peer(uuid) ON DELETE CASCADE,
+    public_key TEXT NOT NULL,
+    rx_bytes BIGINT NOT NULL,
+    tx_bytes BIGINT NOT NULL,
+    sampled_at TIMESTAMPTZ NOT NULL
+);
+
+-- peer_usage table: traffic deltas per sample (raw) and rolled up per hour and per UTC day;
+-- rows outlive their peer so that interface totals stay complete
+CREATE TABLE IF NOT EXISTS peer_usage (
+    resolution TEXT NOT NULL CHECK (resolution IN ('raw', 'hour', 'day')),
+    peer_uuid UUID NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    interface TEXT NOT NULL,
+    rx_bytes BIGINT NOT NULL DEFAULT 0,
+    tx_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (resolution, peer_uuid, bucket)
+);
+CREATE INDEX IF NOT EXISTS peer_usage_interface_idx ON peer_usage (resolution, interface, bucket);
+
+-- Data quotas, inherited like the client settings; quota_exceeded_at marks peers suspended until their period resets
+ALTER TABLE peer_group ADD COLUMN IF NOT EXISTS quota_bytes BIGINT CHECK (quota_bytes > 0);
+ALTER TABLE peer_group ADD COLUMN IF NOT EXISTS quota_direction TEXT CHECK (quota_direction IN ('rx', 'tx', 'total'));
+ALTER TABLE peer_group ADD COLUMN IF NOT EXISTS quota_period TEXT CHECK (quota_period IN ('day', 'week', 'month', 'never'));
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS quota_bytes BIGINT CHECK (quota_bytes > 0);
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS quota_direction TEXT CHECK (quota_direction IN ('rx', 'tx', 'total'));
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS quota_period TEXT CHECK (quota_period IN ('day', 'week', 'month', 'never'));
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS quota_exceeded_at TIMESTAMPTZ;
+
+-- webhook table: outbound subscriptions to lifecycle events; events is a JSON array of types or families ("peer.*"), empty for all
+CREATE TABLE IF NOT EXISTS webhook (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    url TEXT NOT NULL,
+    events JSONB NOT NULL DEFAULT '[]',
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- webhook_delivery table: one row per event and webhook, updated on every attempt until delivered or given up
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_uuid UUID NOT NULL REFERENCES webhook(uuid) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    status TEXT NOT NULL CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    response_code INTEGER,
+    error TEXT,
+    delivered_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_webhook_idx ON webhook_delivery (webhook_uuid, created_at);
+CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+
+-- peer_session table: connections derived from handshakes, one per peer and endpoint; ended_at is NULL while open
+CREATE TABLE IF NOT EXISTS peer_session (
+    id BIGSERIAL PRIMARY KEY,
+    peer_uuid UUID NOT NULL,
+    interface TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    last_handshake_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ,
+    rx_bytes BIGINT NOT NULL DEFAULT 0,
+    tx_bytes BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS peer_session_peer_idx ON peer_session (peer_uuid, started_at);
+CREATE INDEX IF NOT EXISTS peer_session_started_idx ON peer_session (started_at);
+CREATE UNIQUE INDEX IF NOT EXISTS peer_session_open_idx ON peer_session (peer_uuid) WHERE ended_at IS NULL;
+
+-- alert_rule table: conditions checked every minute for one peer, one group's members, or every peer (both NULL);
+-- minutes is the staleness, the alert duration or the traffic window depending on the kind
+CREATE TABLE IF NOT EXISTS alert_rule (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    peer_uuid UUID REFERENCES peer(uuid) ON DELETE CASCADE,
+    group_uuid UUID REFERENCES peer_group(uuid) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('stale_handshake', 'endpoint_change', 'traffic_spike')),
+    minutes INTEGER NOT NULL CHECK (minutes > 0),
+    bytes BIGINT CHECK (bytes > 0),
+    notifiers JSONB NOT NULL DEFAULT '[]',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (peer_uuid IS NULL OR group_uuid IS NULL),
+    CHECK (kind <> 'traffic_spike' OR bytes IS NOT NULL)
+);
+
+-- alert_notifier table: where alert notifications go
+CREATE TABLE IF NOT EXISTS alert_notifier (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('webhook', 'chat', 'email')),
+    target TEXT NOT NULL,
+    secret TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- alert table: one row per firing of a rule for a peer, resolved_at set when the condition clears
+CREATE TABLE IF NOT EXISTS alert (
+    id BIGSERIAL PRIMARY KEY,
+    rule_uuid UUID NOT NULL REFERENCES alert_rule(uuid) ON DELETE CASCADE,
+    peer_uuid UUID NOT NULL REFERENCES peer(uuid) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    state TEXT NOT NULL CHECK (state IN ('firing', 'resolved')),
+    detail TEXT NOT NULL,
+    fired_at TIMESTAMPTZ NOT NULL,
+    resolved_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS alert_open_idx ON alert (rule_uuid, peer_uuid) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS alert_fired_idx ON alert (fired_at);
+
+-- Staged server key rotation: the next key pair is kept here until it is activated
+ALTER TABLE server ADD COLUMN IF NOT EXISTS pending_private_key TEXT;
+ALTER TABLE server ADD COLUMN IF NOT EXISTS pending_public_key TEXT;
+ALTER TABLE server ADD COLUMN IF NOT EXISTS key_staged_at TIMESTAMPTZ;
+
+-- Multiple interfaces: every peer belongs to one server; existing peers belong to the single server of earlier releases
+ALTER TABLE peer ADD COLUMN IF NOT EXISTS server_uuid UUID REFERENCES server(uuid);
+UPDATE peer SET server_uuid = (SELECT s.uuid FROM server s LIMIT 1) WHERE server_uuid IS NULL;
+ALTER TABLE peer ALTER COLUMN server_uuid SET NOT NULL;
+CREATE INDEX IF NOT EXISTS peer_server_uuid_idx ON peer (server_uuid);
+
+-- node table: remote hosts running `wireguard-ui agent`; token_hash is the SHA-256 of the agent token,
+-- the other columns are what the agent last reported
+CREATE TABLE IF NOT EXISTS node (
+    uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ,
+    address TEXT,
+    hostname TEXT,
+    version TEXT,
+    conf_dir TEXT,
+    external_if TEXT,
+    wg_mode TEXT,
+    last_error TEXT
+);
+
+-- An interface with a node runs on that node's agent; desired_up is whether the agent keeps it running
+ALTER TABLE server ADD COLUMN IF NOT EXISTS node_uuid UUID REFERENCES node(uuid);
+ALTER TABLE server ADD COLUMN IF NOT EXISTS desired_up BOOLEAN NOT NULL DEFAULT TRUE;
+-- Ports only clash on the same host: unique per node, with the local interfaces counted as one node
+DROP INDEX IF EXISTS server_port_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS server_node_port_idx ON server (COALESCE(node_uuid, '00000000-0000-0000-0000-000000000000'::uuid), port);
 
 -- Initialize server row with fixed uuid (skip if already exists)
 INSERT INTO server (uuid, public_ip, port, enable_ipv6, subnet_v4, subnet_v6, private_key, public_key)
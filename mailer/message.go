@@ -0,0 +1,126 @@
+// Package mailer builds MIME messages and delivers them over SMTP.
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"time"
+)
+
+// Attachment is a file part of a message. Inline parts are referenced from the HTML body as cid:<ContentID>.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+	Inline      bool
+	ContentID   string
+}
+
+type Message struct {
+	From        string
+	To          string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Bytes renders the message as multipart/mixed, wrapping a multipart/related part
+// (text and HTML alternatives plus inline images) and the regular attachments.
+func (m Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	mixed := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mixed.Boundary())
+
+	// multipart/related: body alternatives followed by inline images
+	var relatedBuf bytes.Buffer
+	related := multipart.NewWriter(&relatedBuf)
+	var altBuf bytes.Buffer
+	alt := multipart.NewWriter(&altBuf)
+	if err := writeBase64(alt, "text/plain; charset=utf-8", textproto.MIMEHeader{}, []byte(m.Text)); err != nil {
+		return nil, err
+	}
+	if m.HTML != "" {
+		if err := writeBase64(alt, "text/html; charset=utf-8", textproto.MIMEHeader{}, []byte(m.HTML)); err != nil {
+			return nil, err
+		}
+	}
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+	if err := writeRaw(related, fmt.Sprintf("multipart/alternative; boundary=%q", alt.Boundary()), altBuf.Bytes()); err != nil {
+		return nil, err
+	}
+	for _, a := range m.Attachments {
+		if !a.Inline {
+			continue
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-ID", "<"+a.ContentID+">")
+		h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.Filename}))
+		if err := writeBase64(related, a.ContentType, h, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := related.Close(); err != nil {
+		return nil, err
+	}
+	if err := writeRaw(mixed, fmt.Sprintf("multipart/related; boundary=%q", related.Boundary()), relatedBuf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, a := range m.Attachments {
+		if a.Inline {
+			continue
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+		if err := writeBase64(mixed, a.ContentType, h, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeRaw(w *multipart.Writer, contentType string, body []byte) error {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(body)
+	return err
+}
+
+// writeBase64 writes a base64 part with lines wrapped at 76 characters as required by RFC 2045.
+func writeBase64(w *multipart.Writer, contentType string, h textproto.MIMEHeader, body []byte) error {
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	enc := base64.StdEncoding.EncodeToString(body)
+	for len(enc) > 76 {
+		if _, err := part.Write([]byte(enc[:76] + "\r\n")); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = part.Write([]byte(enc + "\r\n"))
+	return err
+}
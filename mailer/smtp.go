@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+)
+
+// ErrNotConfigured is returned when SMTP_HOST is not set.
+var ErrNotConfigured = errors.New("smtp is not configured")
+
+// Send delivers the message using the SMTP settings in cfg.
+// SMTP_TLS selects implicit TLS ("tls"), mandatory STARTTLS ("starttls") or plaintext ("none").
+func Send(cfg *config.Config, m Message) error {
+	if cfg.SMTPHost == "" {
+		return ErrNotConfigured
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", m.From, err)
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", m.To, err)
+	}
+	body, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort)
+	tlsConfig := &tls.Config{ServerName: cfg.SMTPHost}
+	var client *smtp.Client
+	switch cfg.SMTPTLS {
+	case "tls":
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return err
+		}
+		if client, err = smtp.NewClient(conn, cfg.SMTPHost); err != nil {
+			conn.Close()
+			return err
+		}
+	case "starttls", "none":
+		if client, err = smtp.Dial(addr); err != nil {
+			return err
+		}
+		if cfg.SMTPTLS == "starttls" {
+			if ok, _ := client.Extension("STARTTLS"); !ok {
+				client.Close()
+				return errors.New("smtp server does not support STARTTLS")
+			}
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown SMTP_TLS mode %q (expected starttls, tls or none)", cfg.SMTPTLS)
+	}
+	defer client.Close()
+
+	if cfg.SMTPUsername != "" {
+		// PlainAuth refuses to send credentials over an unencrypted connection except to localhost
+		if err := client.Auth(smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
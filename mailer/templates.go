@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Built-in templates for the peer config email. Each can be overridden by a file of the same
+// name in EMAIL_TEMPLATE_DIR: peer_config.subject.tmpl, peer_config.txt.tmpl, peer_config.html.tmpl.
+const (
+	defaultSubject = `Your WireGuard configuration{{if .Name}} for {{.Name}}{{end}}`
+	defaultText    = `Hello,
+
+attached is your WireGuard configuration{{if .Name}} for "{{.Name}}"{{end}}.
+Import the .conf file into the WireGuard app{{if .HasQR}}, or scan the QR code in the HTML version of this email{{end}}.
+{{if .BringYourOwnKey}}
+The file contains a placeholder instead of a private key: replace it with the private key you generated on your device.
+{{end}}
+Address: {{.Address}}
+Endpoint: {{.Endpoint}}
+{{- if .ExpiresAt}}
+Expires: {{.ExpiresAt}}
+{{- end}}
+`
+	defaultHTML = `<p>Hello,</p>
+<p>attached is your WireGuard configuration{{if .Name}} for &ldquo;{{.Name}}&rdquo;{{end}}.
+Import the <code>.conf</code> file into the WireGuard app{{if .HasQR}} or scan this QR code{{end}}.</p>
+{{if .HasQR}}<p><img src="cid:{{.QRContentID}}" alt="WireGuard configuration QR code" width="320" height="320"></p>{{end}}
+{{if .BringYourOwnKey}}<p>The file contains a placeholder instead of a private key: replace it with the private key you generated on your device.</p>{{end}}
+<p>Address: {{.Address}}<br>Endpoint: {{.Endpoint}}{{if .ExpiresAt}}<br>Expires: {{.ExpiresAt}}{{end}}</p>
+`
+)
+
+// PeerConfigData is the data available to the peer config templates.
+type PeerConfigData struct {
+	Name            string
+	UUID            string
+	Address         string
+	Endpoint        string
+	ExpiresAt       string
+	HasQR           bool
+	QRContentID     string
+	BringYourOwnKey bool
+}
+
+// RenderPeerConfig returns subject, plain-text and HTML bodies for the peer config email.
+func RenderPeerConfig(dir string, data PeerConfigData) (subject, text, html string, err error) {
+	if subject, err = renderText(dir, "peer_config.subject.tmpl", defaultSubject, data); err != nil {
+		return
+	}
+	subject = strings.TrimSpace(subject)
+	if text, err = renderText(dir, "peer_config.txt.tmpl", defaultText, data); err != nil {
+		return
+	}
+	src, err := loadTemplate(dir, "peer_config.html.tmpl", defaultHTML)
+	if err != nil {
+		return
+	}
+	t, err := htmltemplate.New("html").Parse(src)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return
+	}
+	html = buf.String()
+	return
+}
+
+func renderText(dir, name, def string, data any) (string, error) {
+	src, err := loadTemplate(dir, name, def)
+	if err != nil {
+		return "", err
+	}
+	t, err := template.New(name).Parse(src)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// loadTemplate reads dir/name when present and falls back to the built-in template.
+func loadTemplate(dir, name, def string) (string, error) {
+	if dir == "" {
+		return def, nil
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return def, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
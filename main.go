@@ -4,15 +4,23 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"github.com/StellaShiina/wireguard-ui/agent"
+	"github.com/StellaShiina/wireguard-ui/alerts"
 	"github.com/StellaShiina/wireguard-ui/config"
 	"github.com/StellaShiina/wireguard-ui/db"
 	"github.com/StellaShiina/wireguard-ui/handlers"
+	"github.com/StellaShiina/wireguard-ui/importer"
+	"github.com/StellaShiina/wireguard-ui/metrics"
 	"github.com/StellaShiina/wireguard-ui/middleware"
 	"github.com/StellaShiina/wireguard-ui/netutil"
+	"github.com/StellaShiina/wireguard-ui/stats"
+	"github.com/StellaShiina/wireguard-ui/webhooks"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
 )
 
 type Server struct {
@@ -49,6 +57,10 @@ func main() {
 	}
 	// Initialize database connection (normal operation mode)
 	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
 	// Automatically detect the default external network interface name when the program starts
 	if cfg.WGExternalIF == "" {
 		if ifname, err := netutil.DetectDefaultInterface(); err == nil && ifname != "" {
@@ -58,12 +70,43 @@ func main() {
 			fmt.Printf("[WG] Could not detect external interface automatically: %v\n", err)
 		}
 	}
+	// The agent runs the interfaces of a remote node; it has no database and takes its orders from the panel
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		if err := agent.Run(cfg, os.Args[2:]); err != nil {
+			fmt.Printf("agent failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := db.Init(cfg); err != nil {
 		fmt.Printf("failed to init database: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Subcommands run against the same configuration and database, then exit
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importer.Run(cfg, os.Args[2:]); err != nil {
+			fmt.Printf("import failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Drop expired peers from the server configuration as their expiry time passes
+	go wireguard.WatchExpiry(cfg, time.Minute)
+	// Publish handshakes, peers going offline and interface up/down for the event stream
+	go wireguard.WatchRuntime(cfg, 5*time.Second)
+	// Deliver events to the configured webhooks, resuming pending retries
+	go webhooks.Run()
+	// Evaluate alert rules and notify
+	go alerts.Run(cfg)
+	// Record per-peer traffic from the interface counters
+	go stats.Run(cfg)
+	// Per-peer and interface metrics are read from the interface and the database on every scrape
+	metrics.Registry.MustRegister(stats.NewExporter(cfg))
+
 	r := gin.Default()
+	r.Use(middleware.Metrics())
 
 	// Load HTML templates for /login and /
 	r.LoadHTMLGlob("templates/*")
@@ -77,28 +120,82 @@ func main() {
 		auth.GET("/check", handlers.CheckAuthHandler)
 	}
 
+	// Public share links; the signed token in the path is the only credential
+	share := r.Group("/share")
+	{
+		share.GET("/:token", handlers.ShareDownload)
+		share.GET("/:token/qr", handlers.ShareQR)
+	}
+
+	// Prometheus scrape endpoint; protected by METRICS_TOKEN instead of the login session
+	r.GET("/metrics", handlers.Metrics)
+
+	// Node agents authenticate with their node token instead of the login session
+	r.POST(agent.SyncPath, handlers.AgentSync)
+
 	// Pages
 	r.GET("/login", middleware.RedirectIfAuthenticated(), handlers.LoginPage)
 	r.GET("/", middleware.AuthPageRequired(), handlers.IndexPage)
 
 	api := r.Group("/api/v1", middleware.AuthRequired())
 	{
-		configs := api.Group("/configs")
+		// The interface routes work on WG_INTERFACE here and on :name under /interfaces/:name
+		interfaceRoutes(api)
+		ifaces := api.Group("/interfaces")
 		{
-			configs.GET("", handlers.GetConfigs)
-			configs.POST("/server/:uuid", handlers.UpdateServer)
-			configs.POST("/peer", handlers.CreatePeer)
-			configs.PUT("/peer/:uuid", handlers.UpdatePeer)
-			configs.DELETE("/peer/:uuid", handlers.DeletePeer)
-			configs.GET("/peer/:uuid", handlers.DownloadPeerConfig)
+			ifaces.GET("", handlers.ListInterfaces)
+			ifaces.POST("", handlers.CreateInterface)
+			ifaces.GET("/:name", handlers.GetInterface)
+			ifaces.DELETE("/:name", handlers.DeleteInterface)
+			interfaceRoutes(ifaces.Group("/:name"))
 		}
-		wg := api.Group("/wg")
+		nodes := api.Group("/nodes")
 		{
-			wg.POST("/start", handlers.WGStart)
-			wg.POST("/stop", handlers.WGStop)
-			wg.POST("/restart", handlers.WGRestart)
-			wg.GET("/status", handlers.WGStatus)
-			wg.GET("/show", handlers.WGShow)
+			nodes.GET("", handlers.ListNodes)
+			nodes.POST("", handlers.CreateNode)
+			nodes.POST("/:uuid/token", handlers.RotateNodeToken)
+			nodes.DELETE("/:uuid", handlers.DeleteNode)
+		}
+		api.GET("/events", handlers.Events)
+		api.GET("/shares", handlers.GetShareLinks)
+		api.DELETE("/shares/:uuid", handlers.RevokeShareLink)
+		groups := api.Group("/groups")
+		{
+			groups.GET("", handlers.GetGroups)
+			groups.POST("", handlers.CreateGroup)
+			groups.PUT("/:uuid", handlers.UpdateGroup)
+			groups.DELETE("/:uuid", handlers.DeleteGroup)
+			groups.POST("/:uuid/peers", handlers.AddGroupPeers)
+			groups.DELETE("/:uuid/peers/:peer", handlers.RemoveGroupPeer)
+		}
+		acl := api.Group("/acl")
+		{
+			acl.GET("", handlers.GetACLRules)
+			acl.POST("", handlers.CreateACLRule)
+			acl.PUT("/:uuid", handlers.UpdateACLRule)
+			acl.DELETE("/:uuid", handlers.DeleteACLRule)
+		}
+		hooks := api.Group("/webhooks")
+		{
+			hooks.GET("", handlers.GetWebhooks)
+			hooks.POST("", handlers.CreateWebhook)
+			hooks.PUT("/:uuid", handlers.UpdateWebhook)
+			hooks.DELETE("/:uuid", handlers.DeleteWebhook)
+			hooks.GET("/:uuid/deliveries", handlers.GetWebhookDeliveries)
+			hooks.POST("/:uuid/test", handlers.TestWebhook)
+		}
+		alertsGroup := api.Group("/alerts")
+		{
+			alertsGroup.GET("", handlers.ListAlerts)
+			alertsGroup.GET("/rules", handlers.GetAlertRules)
+			alertsGroup.POST("/rules", handlers.CreateAlertRule)
+			alertsGroup.PUT("/rules/:uuid", handlers.UpdateAlertRule)
+			alertsGroup.DELETE("/rules/:uuid", handlers.DeleteAlertRule)
+			alertsGroup.GET("/notifiers", handlers.GetAlertNotifiers)
+			alertsGroup.POST("/notifiers", handlers.CreateAlertNotifier)
+			alertsGroup.PUT("/notifiers/:uuid", handlers.UpdateAlertNotifier)
+			alertsGroup.DELETE("/notifiers/:uuid", handlers.DeleteAlertNotifier)
+			alertsGroup.POST("/notifiers/:uuid/test", handlers.TestAlertNotifier)
 		}
 	}
 
@@ -107,5 +204,43 @@ func main() {
 	r.Run(addr)
 }
 
+// interfaceRoutes registers the routes that work on one interface.
+func interfaceRoutes(api *gin.RouterGroup) {
+	configs := api.Group("/configs")
+	{
+		configs.GET("", handlers.GetConfigs)
+		configs.POST("/server/:uuid", handlers.UpdateServer)
+		configs.POST("/server/:uuid/rotate-keys", handlers.RotateServerKeys)
+		configs.POST("/server/:uuid/rotate-keys/activate", handlers.ActivateServerKeys)
+		configs.DELETE("/server/:uuid/rotate-keys", handlers.DiscardServerKeys)
+		configs.GET("/server/:uuid/rotate-keys/configs", handlers.StagedPeerConfigs)
+		configs.POST("/peer", handlers.CreatePeer)
+		configs.PUT("/peer/:uuid", handlers.UpdatePeer)
+		configs.DELETE("/peer/:uuid", handlers.DeletePeer)
+		configs.GET("/peer/:uuid", handlers.DownloadPeerConfig)
+		configs.GET("/peer/:uuid/qr", handlers.PeerConfigQR)
+		configs.POST("/peer/:uuid/rotate-keys", handlers.RotatePeerKeys)
+		configs.POST("/peer/:uuid/share", handlers.CreateShareLink)
+		configs.POST("/peer/:uuid/email", handlers.EmailPeerConfig)
+		configs.GET("/peer/:uuid/email", handlers.GetEmailDeliveries)
+	}
+	api.GET("/summary", handlers.Summary)
+	api.GET("/peers", handlers.ListPeers)
+	api.GET("/peers/:uuid/usage", handlers.PeerUsage)
+	api.GET("/peers/:uuid/sessions", handlers.PeerSessions)
+	api.GET("/sessions", handlers.ListSessions)
+	api.GET("/usage", handlers.InterfaceUsage)
+	wg := api.Group("/wg")
+	{
+		wg.POST("/start", handlers.WGStart)
+		wg.POST("/stop", handlers.WGStop)
+		wg.POST("/restart", handlers.WGRestart)
+		wg.POST("/apply", handlers.WGApply)
+		wg.GET("/status", handlers.WGStatus)
+		wg.GET("/show", handlers.WGShow)
+		wg.GET("/peers", handlers.WGPeers)
+	}
+}
+
 func (Server) TableName() string { return "server" }
 func (Peer) TableName() string   { return "peer" }
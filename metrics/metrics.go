@@ -0,0 +1,85 @@
+// Package metrics holds the Prometheus metrics recorded across the service. It only depends on
+// the Prometheus client so that every other package can record into it.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "wireguard_ui"
+
+// Registry holds every metric exposed on /metrics, including the Go runtime and process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	configGenerateDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "config_generate_duration_seconds",
+		Help:      "Time spent writing the server config and its helper scripts.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	configGenerateErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_generate_errors_total",
+		Help:      "Server config generations that failed.",
+	})
+	applyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "apply_duration_seconds",
+		Help:      "Time spent applying changes to the running interface, by kind (peer, acl, shaping).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind"})
+	applyErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apply_errors_total",
+		Help:      "Changes to the running interface that failed, by kind (peer, acl, shaping).",
+	}, []string{"kind"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		configGenerateDuration, configGenerateErrors,
+		applyDuration, applyErrors,
+		httpRequests, httpDuration,
+	)
+}
+
+// ObserveGenerate records one server config generation that started at start.
+func ObserveGenerate(start time.Time, err error) {
+	configGenerateDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		configGenerateErrors.Inc()
+	}
+}
+
+// ObserveApply records one change of the given kind to the running interface that started at start.
+func ObserveApply(kind string, start time.Time, err error) {
+	applyDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		applyErrors.WithLabelValues(kind).Inc()
+	}
+}
+
+// ObserveRequest records one HTTP request. route is the matched pattern (e.g. /api/v1/configs/peer/:uuid),
+// not the raw path, to keep the label set bounded.
+func ObserveRequest(method, route string, code int, start time.Time) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+}
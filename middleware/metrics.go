@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records the count and latency of every request handled by the router.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(c.Request.Method, route, c.Writer.Status(), start)
+	}
+}
@@ -0,0 +1,101 @@
+package netutil
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+)
+
+// MaxIPv6Allocations is how many addresses the IPv6 allocator (get_next_free_ipv6 in init.sql) tries.
+const MaxIPv6Allocations = 100000
+
+// ParseSubnet parses a server subnet, which must be a network address of the given family ("ipv4" or
+// "ipv6") with room for at least one peer.
+func ParseSubnet(family, s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid %s subnet %q", family, s)
+	}
+	if p.Addr().Is4() != (family == "ipv4") || p.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("%s subnet %q is not an %s prefix", family, s, family)
+	}
+	if p.Masked() != p {
+		return netip.Prefix{}, fmt.Errorf("%s subnet %q has host bits set (use %s)", family, s, p.Masked())
+	}
+	if p.Addr().Is4() && p.Bits() > 30 || p.Bits() > 127 {
+		return netip.Prefix{}, fmt.Errorf("%s subnet %q is too small", family, s)
+	}
+	return p, nil
+}
+
+// hostRange returns the first and last address offsets the allocator hands out in a subnet: the network
+// address is the server's and, for IPv4, the broadcast address is skipped.
+func hostRange(p netip.Prefix) (first, last *big.Int) {
+	hostBits := uint(p.Addr().BitLen() - p.Bits())
+	last = new(big.Int).Lsh(big.NewInt(1), hostBits)
+	last.Sub(last, big.NewInt(1))
+	if p.Addr().Is4() {
+		last.Sub(last, big.NewInt(1))
+	}
+	return big.NewInt(1), last
+}
+
+func addrInt(a netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(a.AsSlice())
+}
+
+func intAddr(i *big.Int, is4 bool) netip.Addr {
+	buf := make([]byte, 16)
+	if is4 {
+		buf = buf[:4]
+	}
+	a, _ := netip.AddrFromSlice(i.FillBytes(buf))
+	return a
+}
+
+// Renumber moves peer addresses (host prefixes like "10.0.0.5/32") from one subnet to another. Each keeps
+// its offset from the network address when that offset is valid in the new subnet; the others, and
+// addresses outside the old subnet, get the next free address the way the allocator would pick it.
+// The result is in the order of addrs.
+func Renumber(from, to netip.Prefix, addrs []string) ([]string, error) {
+	is4 := to.Addr().Is4()
+	base, fromBase := addrInt(to.Addr()), addrInt(from.Addr())
+	first, last := hostRange(to)
+	hostLen := to.Addr().BitLen()
+
+	out := make([]string, len(addrs))
+	taken := map[string]bool{}
+	var pending []int
+	for i, a := range addrs {
+		p, err := netip.ParsePrefix(a)
+		if err != nil || !from.Contains(p.Addr()) {
+			pending = append(pending, i)
+			continue
+		}
+		offset := new(big.Int).Sub(addrInt(p.Addr()), fromBase)
+		if offset.Cmp(first) < 0 || offset.Cmp(last) > 0 {
+			pending = append(pending, i)
+			continue
+		}
+		na := netip.PrefixFrom(intAddr(offset.Add(offset, base), is4), hostLen).String()
+		out[i] = na
+		taken[na] = true
+	}
+
+	next := new(big.Int).Set(first)
+	for _, i := range pending {
+		for {
+			if next.Cmp(last) > 0 || !is4 && next.Cmp(big.NewInt(MaxIPv6Allocations)) > 0 {
+				return nil, fmt.Errorf("subnet %s has no room for %d peers", to, len(addrs))
+			}
+			na := netip.PrefixFrom(intAddr(new(big.Int).Add(base, next), is4), hostLen).String()
+			next.Add(next, big.NewInt(1))
+			if !taken[na] {
+				out[i] = na
+				taken[na] = true
+				break
+			}
+		}
+	}
+	return out, nil
+}
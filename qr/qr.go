@@ -0,0 +1,73 @@
+// Package qr renders QR codes as PNG or SVG.
+package qr
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	DefaultSize = 512
+	MinSize     = 128
+	MaxSize     = 2048
+)
+
+// Level is a QR error-correction level.
+type Level = qrcode.RecoveryLevel
+
+const Medium = qrcode.Medium
+
+// ParseLevel maps L, M, Q or H (case-insensitive) to an error-correction level; empty means M.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return qrcode.Low, nil
+	case "", "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	default:
+		return 0, fmt.Errorf("unknown error correction level %q (expected L, M, Q or H)", s)
+	}
+}
+
+// PNG encodes content as a size x size pixel PNG.
+func PNG(content string, size int, level Level) ([]byte, error) {
+	return qrcode.Encode(content, level, size)
+}
+
+// SVG encodes content as an SVG document of size x size user units.
+// Each dark module becomes a rectangle so the image scales without blurring.
+func SVG(content string, size int, level Level) ([]byte, error) {
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := code.Bitmap() // includes the quiet zone
+	n := len(bitmap)
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", size, size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`+"\n", n, n)
+	b.WriteString(`<path fill="#000000" d="`)
+	for y, row := range bitmap {
+		// Merge horizontal runs of dark modules into one path segment
+		for x := 0; x < n; x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/>` + "\n</svg>\n")
+	return []byte(b.String()), nil
+}
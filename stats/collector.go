@@ -0,0 +1,164 @@
+// Package stats records per-peer traffic from the interface counters and serves it as time series.
+package stats
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pruneEvery is how often expired usage rows are deleted.
+const pruneEvery = time.Hour
+
+// Run samples the counters of every interface, tracks connection sessions and enforces data quotas every
+// STATS_INTERVAL_SECONDS until the process exits.
+// It returns immediately when the interval is 0.
+func Run(cfg *config.Config) {
+	interval := time.Duration(atoi(cfg.StatsInterval, 60)) * time.Second
+	if interval <= 0 {
+		log.Printf("[WG] traffic accounting and session tracking disabled")
+		return
+	}
+	var lastPrune time.Time
+	for {
+		now := time.Now()
+		ifaces, err := wireguard.Interfaces(cfg)
+		if err != nil {
+			log.Printf("[WG] query interfaces failed: %v", err)
+		}
+		for _, ifc := range ifaces {
+			if err := Sample(ifc.Cfg, now); err != nil {
+				log.Printf("[WG] %s: traffic sample failed: %v", ifc.Server.Interface, err)
+			}
+			if err := TrackSessions(ifc.Cfg, now); err != nil {
+				log.Printf("[WG] %s: session tracking failed: %v", ifc.Server.Interface, err)
+			}
+		}
+		if err := EnforceQuotas(cfg, now); err != nil {
+			log.Printf("[WG] quota check failed: %v", err)
+		}
+		if now.Sub(lastPrune) >= pruneEvery {
+			if err := Prune(cfg, now); err != nil {
+				log.Printf("[WG] prune traffic history failed: %v", err)
+			} else if err := PruneSessions(cfg, now); err != nil {
+				log.Printf("[WG] prune sessions failed: %v", err)
+			} else {
+				lastPrune = now
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Sample reads the counters of one interface (cfg.WGInterface) once and records the traffic since the previous sample.
+// A down interface is not an error: there is simply nothing to record.
+func Sample(cfg *config.Config, now time.Time) error {
+	if !wireguard.InterfaceUp(cfg) {
+		return nil
+	}
+	dump, err := wireguard.ShowDump(cfg)
+	if err != nil {
+		return err
+	}
+	var peers []db.Peer
+	if err := db.DB.Select("uuid", "public_key").Find(&peers).Error; err != nil {
+		return err
+	}
+	byKey := map[string]string{}
+	for _, p := range peers {
+		byKey[p.PublicKey] = p.UUID
+	}
+	var counters []db.PeerCounter
+	if err := db.DB.Find(&counters).Error; err != nil {
+		return err
+	}
+	last := map[string]db.PeerCounter{}
+	for _, c := range counters {
+		last[c.PeerUUID] = c
+	}
+
+	var updated []db.PeerCounter
+	var usage []db.PeerUsage
+	for _, rt := range dump.Peers {
+		uuid, ok := byKey[rt.PublicKey]
+		if !ok {
+			continue
+		}
+		rx, tx := rt.RxBytes, rt.TxBytes
+		// Counters restart from zero when the interface is recreated, the peer is re-added, or its key
+		// changes; everything counted since then is new traffic
+		if prev, ok := last[uuid]; ok && prev.PublicKey == rt.PublicKey && rx >= prev.RxBytes && tx >= prev.TxBytes {
+			rx -= prev.RxBytes
+			tx -= prev.TxBytes
+		}
+		updated = append(updated, db.PeerCounter{PeerUUID: uuid, PublicKey: rt.PublicKey, RxBytes: rt.RxBytes, TxBytes: rt.TxBytes, SampledAt: now})
+		if rx == 0 && tx == 0 {
+			continue
+		}
+		for _, res := range []string{db.UsageRaw, db.UsageHour, db.UsageDay} {
+			usage = append(usage, db.PeerUsage{Resolution: res, PeerUUID: uuid, Bucket: BucketStart(res, now), Interface: cfg.WGInterface, RxBytes: rx, TxBytes: tx})
+		}
+	}
+	if len(updated) == 0 {
+		return nil
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "peer_uuid"}},
+			DoUpdates: clause.AssignmentColumns([]string{"public_key", "rx_bytes", "tx_bytes", "sampled_at"}),
+		}).Create(&updated).Error
+		if err != nil || len(usage) == 0 {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "resolution"}, {Name: "peer_uuid"}, {Name: "bucket"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"rx_bytes": gorm.Expr("peer_usage.rx_bytes + excluded.rx_bytes"),
+				"tx_bytes": gorm.Expr("peer_usage.tx_bytes + excluded.tx_bytes"),
+			}),
+		}).Create(&usage).Error
+	})
+}
+
+// BucketStart returns the start of the bucket containing t. Raw buckets are single samples;
+// hours and days are in UTC.
+func BucketStart(resolution string, t time.Time) time.Time {
+	t = t.UTC()
+	switch resolution {
+	case db.UsageHour:
+		return t.Truncate(time.Hour)
+	case db.UsageDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(time.Second)
+	}
+}
+
+// Prune deletes usage rows older than their retention period.
+func Prune(cfg *config.Config, now time.Time) error {
+	cutoffs := map[string]time.Time{
+		db.UsageRaw:  now.Add(-time.Duration(atoi(cfg.StatsRawRetention, 48)) * time.Hour),
+		db.UsageHour: now.AddDate(0, 0, -atoi(cfg.StatsHourlyRetention, 90)),
+		db.UsageDay:  now.AddDate(0, 0, -atoi(cfg.StatsDailyRetention, 730)),
+	}
+	for res, cutoff := range cutoffs {
+		if err := db.DB.Where("resolution = ? AND bucket < ?", res, cutoff).Delete(&db.PeerUsage{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func atoi(s string, def int) int {
+	if v, err := strconv.Atoi(s); err == nil {
+		return v
+	}
+	return def
+}
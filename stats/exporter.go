@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"log"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	interfaceUpDesc = prometheus.NewDesc("wireguard_ui_interface_up",
+		"Whether the WireGuard interface exists (1) or not (0).", []string{"interface"}, nil)
+	peerRxDesc = prometheus.NewDesc("wireguard_ui_peer_receive_bytes_total",
+		"Bytes received from the peer since it was added to the interface.", []string{"interface", "uuid", "name"}, nil)
+	peerTxDesc = prometheus.NewDesc("wireguard_ui_peer_transmit_bytes_total",
+		"Bytes sent to the peer since it was added to the interface.", []string{"interface", "uuid", "name"}, nil)
+	peerHandshakeDesc = prometheus.NewDesc("wireguard_ui_peer_last_handshake_seconds",
+		"Unix time of the peer's latest handshake; 0 if it never completed one.", []string{"interface", "uuid", "name"}, nil)
+	peersDesc = prometheus.NewDesc("wireguard_ui_peers",
+		"Number of peers by state (enabled, disabled, expired, suspended, active, online); states overlap.", []string{"interface", "state"}, nil)
+)
+
+// Exporter reads the interfaces and the database on every scrape.
+type Exporter struct {
+	cfg *config.Config
+}
+
+func NewExporter(cfg *config.Config) *Exporter {
+	return &Exporter{cfg: cfg}
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- interfaceUpDesc
+	ch <- peerRxDesc
+	ch <- peerTxDesc
+	ch <- peerHandshakeDesc
+	ch <- peersDesc
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	ifaces, err := wireguard.Interfaces(e.cfg)
+	if err != nil {
+		log.Printf("[WG] metrics: query interfaces failed: %v", err)
+		return
+	}
+	for _, ifc := range ifaces {
+		e.collectInterface(ch, ifc)
+	}
+}
+
+func (e *Exporter) collectInterface(ch chan<- prometheus.Metric, ifc wireguard.Interface) {
+	iface := ifc.Server.Interface
+	dump, err := wireguard.ShowDump(ifc.Cfg)
+	up := 0.0
+	runtime := map[string]wireguard.PeerDump{}
+	if err == nil {
+		up = 1
+		for _, p := range dump.Peers {
+			runtime[p.PublicKey] = p
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(interfaceUpDesc, prometheus.GaugeValue, up, iface)
+
+	var peers []db.Peer
+	if err := db.DB.Scopes(db.OnServer(ifc.Server.UUID)).Select("uuid", "name", "public_key", "enabled", "expires_at", "quota_exceeded_at").Find(&peers).Error; err != nil {
+		log.Printf("[WG] metrics: query peers failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	counts := map[string]float64{"enabled": 0, "disabled": 0, "expired": 0, "suspended": 0, "active": 0, "online": 0}
+	for _, p := range peers {
+		if p.Enabled {
+			counts["enabled"]++
+		} else {
+			counts["disabled"]++
+		}
+		if p.Expired(now) {
+			counts["expired"]++
+		}
+		if p.QuotaExceededAt != nil {
+			counts["suspended"]++
+		}
+		if p.Active(now) {
+			counts["active"]++
+		}
+
+		rt, ok := runtime[p.PublicKey]
+		if !ok {
+			continue
+		}
+		if rt.Online(now) {
+			counts["online"]++
+		}
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		handshake := 0.0
+		if rt.LatestHandshake != nil {
+			handshake = float64(rt.LatestHandshake.Unix())
+		}
+		ch <- prometheus.MustNewConstMetric(peerRxDesc, prometheus.CounterValue, float64(rt.RxBytes), iface, p.UUID, name)
+		ch <- prometheus.MustNewConstMetric(peerTxDesc, prometheus.CounterValue, float64(rt.TxBytes), iface, p.UUID, name)
+		ch <- prometheus.MustNewConstMetric(peerHandshakeDesc, prometheus.GaugeValue, handshake, iface, p.UUID, name)
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(peersDesc, prometheus.GaugeValue, n, iface, state)
+	}
+}
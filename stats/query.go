@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+)
+
+// Point is the traffic within one bucket of a series.
+type Point struct {
+	Time    time.Time
+	RxBytes int64
+	TxBytes int64
+}
+
+// Filter selects the usage rows of a series; empty fields match everything.
+type Filter struct {
+	PeerUUID  string
+	Interface string
+}
+
+// AutoStep picks the finest resolution that keeps a series over the given range reasonably short.
+func AutoStep(from, to time.Time) string {
+	switch d := to.Sub(from); {
+	case d <= 6*time.Hour:
+		return db.UsageRaw
+	case d <= 31*24*time.Hour:
+		return db.UsageHour
+	default:
+		return db.UsageDay
+	}
+}
+
+// ValidStep reports whether step names a stored resolution.
+func ValidStep(step string) bool {
+	return step == db.UsageRaw || step == db.UsageHour || step == db.UsageDay
+}
+
+// Series returns the traffic between from (rounded down to the step) and to, one point per non-empty bucket.
+func Series(f Filter, from, to time.Time, step string) ([]Point, error) {
+	if !ValidStep(step) {
+		return nil, fmt.Errorf("unknown step %q", step)
+	}
+	q := db.DB.Model(&db.PeerUsage{}).
+		Select("bucket AS time, sum(rx_bytes) AS rx_bytes, sum(tx_bytes) AS tx_bytes").
+		Where("resolution = ? AND bucket >= ? AND bucket < ?", step, BucketStart(step, from), to)
+	if f.PeerUUID != "" {
+		q = q.Where("peer_uuid = ?", f.PeerUUID)
+	}
+	if f.Interface != "" {
+		q = q.Where("interface = ?", f.Interface)
+	}
+	points := []Point{}
+	if err := q.Group("bucket").Order("bucket").Scan(&points).Error; err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// Total sums a series.
+func Total(points []Point) (rx, tx int64) {
+	for _, p := range points {
+		rx += p.RxBytes
+		tx += p.TxBytes
+	}
+	return rx, tx
+}
@@ -0,0 +1,188 @@
+package stats
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+)
+
+// QuotaStatuses computes the effective quota of each peer that has one. Group must be preloaded.
+func QuotaStatuses(peers []db.Peer, now time.Time) (map[string]db.QuotaStatus, error) {
+	statuses := map[string]db.QuotaStatus{}
+	byStart := map[time.Time][]string{}
+	for _, p := range peers {
+		st := p.Settings()
+		if st.QuotaBytes <= 0 {
+			continue
+		}
+		q := db.QuotaStatus{LimitBytes: st.QuotaBytes, Direction: st.QuotaDirection, Period: st.QuotaPeriod}
+		if q.Direction == "" {
+			q.Direction = db.QuotaTotal
+		}
+		if q.Period == "" {
+			q.Period = db.QuotaMonth
+		}
+		q.PeriodStart, q.ResetsAt = db.QuotaPeriodStart(q.Period, now)
+		statuses[p.UUID] = q
+		byStart[q.PeriodStart] = append(byStart[q.PeriodStart], p.UUID)
+	}
+
+	// Periods start at UTC midnight, so the daily rollups cover them exactly
+	type row struct {
+		PeerUUID string
+		RxBytes  int64
+		TxBytes  int64
+	}
+	for start, uuids := range byStart {
+		var rows []row
+		err := db.DB.Model(&db.PeerUsage{}).
+			Select("peer_uuid, sum(rx_bytes) AS rx_bytes, sum(tx_bytes) AS tx_bytes").
+			Where("resolution = ? AND bucket >= ? AND peer_uuid IN ?", db.UsageDay, start, uuids).
+			Group("peer_uuid").Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			q := statuses[r.PeerUUID]
+			switch q.Direction {
+			case db.QuotaRx:
+				q.UsedBytes = r.RxBytes
+			case db.QuotaTx:
+				q.UsedBytes = r.TxBytes
+			default:
+				q.UsedBytes = r.RxBytes + r.TxBytes
+			}
+			statuses[r.PeerUUID] = q
+		}
+	}
+	for uuid, q := range statuses {
+		q.Exceeded = q.UsedBytes >= q.LimitBytes
+		statuses[uuid] = q
+	}
+	return statuses, nil
+}
+
+// AttachQuotas fills in the Quota field of peers that have a quota. Group must be preloaded.
+func AttachQuotas(peers []db.Peer, now time.Time) error {
+	statuses, err := QuotaStatuses(peers, now)
+	if err != nil {
+		return err
+	}
+	for i := range peers {
+		if q, ok := statuses[peers[i].UUID]; ok {
+			peers[i].Quota = &q
+		}
+	}
+	return nil
+}
+
+// EnforceQuotas suspends peers that used up their quota and resumes suspended peers whose period
+// has reset or whose quota was raised or removed, then updates the configs and the running interface.
+func EnforceQuotas(cfg *config.Config, now time.Time) error {
+	var peers []db.Peer
+	if err := db.DB.Preload("Group").Find(&peers).Error; err != nil {
+		return err
+	}
+	statuses, err := QuotaStatuses(peers, now)
+	if err != nil {
+		return err
+	}
+	var suspend, resume []db.Peer
+	for _, p := range peers {
+		q, ok := statuses[p.UUID]
+		exceeded := ok && q.Exceeded
+		switch {
+		case exceeded && p.QuotaExceededAt == nil && p.Active(now):
+			suspend = append(suspend, p)
+		case !exceeded && p.QuotaExceededAt != nil:
+			resume = append(resume, p)
+		}
+	}
+	if len(suspend) == 0 && len(resume) == 0 {
+		return nil
+	}
+
+	for _, p := range suspend {
+		if err := db.DB.Model(&db.Peer{}).Where("uuid = ?", p.UUID).Update("quota_exceeded_at", now).Error; err != nil {
+			return err
+		}
+		q := statuses[p.UUID]
+		_ = db.RecordAudit("", "peer.quota_exceeded", p.UUID, fmt.Sprintf("%s traffic %d of %d bytes this %s", q.Direction, q.UsedBytes, q.LimitBytes, q.Period))
+	}
+	for _, p := range resume {
+		if err := db.DB.Model(&db.Peer{}).Where("uuid = ?", p.UUID).Update("quota_exceeded_at", nil).Error; err != nil {
+			return err
+		}
+		_ = db.RecordAudit("", "peer.quota_reset", p.UUID, "")
+	}
+	log.Printf("[WG] quota: %d peer(s) suspended, %d resumed", len(suspend), len(resume))
+
+	ifaces, err := wireguard.Interfaces(cfg)
+	if err != nil {
+		return err
+	}
+	for _, ifc := range ifaces {
+		if err := applyQuotas(ifc, onServer(suspend, ifc.Server.UUID), onServer(resume, ifc.Server.UUID), statuses, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func onServer(peers []db.Peer, serverUUID string) []db.Peer {
+	var out []db.Peer
+	for _, p := range peers {
+		if p.ServerUUID == serverUUID {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// applyQuotas announces the peers of one interface that were suspended or resumed and updates its
+// configs and the running interface.
+func applyQuotas(ifc wireguard.Interface, suspend, resume []db.Peer, statuses map[string]db.QuotaStatus, now time.Time) error {
+	if len(suspend) == 0 && len(resume) == 0 {
+		return nil
+	}
+	cfg := ifc.Cfg
+	for _, p := range suspend {
+		events.Publish(events.Event{Type: events.PeerSuspended, Interface: cfg.WGInterface, PeerUUID: p.UUID, Data: map[string]any{"name": p.Name, "quota": statuses[p.UUID]}})
+	}
+	for _, p := range resume {
+		events.Publish(events.Event{Type: events.PeerResumed, Interface: cfg.WGInterface, PeerUUID: p.UUID, Data: map[string]any{"name": p.Name}})
+	}
+	if err := wireguard.WriteInterfaceConfigs(cfg, ifc.Server); err != nil {
+		return err
+	}
+	if !wireguard.InterfaceUp(cfg) {
+		return nil
+	}
+	for _, p := range suspend {
+		if err := wireguard.RemovePeer(cfg, p.PublicKey); err != nil {
+			log.Printf("[WG] remove peer %s over quota failed: %v", p.UUID, err)
+		}
+	}
+	// Reload the firewall and shaping first so that a resumed peer never runs without its rules
+	if err := wireguard.ApplyACL(cfg); err != nil {
+		log.Printf("[WG] %v", err)
+	}
+	if err := wireguard.ApplyShaping(cfg); err != nil {
+		log.Printf("[WG] %v", err)
+	}
+	for _, p := range resume {
+		p.QuotaExceededAt = nil
+		if !p.Active(now) {
+			continue
+		}
+		if err := wireguard.SetPeer(cfg, p); err != nil {
+			log.Printf("[WG] re-add peer %s after quota reset failed: %v", p.UUID, err)
+		}
+	}
+	return nil
+}
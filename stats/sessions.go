@@ -0,0 +1,143 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/wireguard"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sessionCounters holds the transfer counters seen on the previous pass, by interface and peer UUID, so
+// that each pass can add the traffic since then to the open sessions. Only Run's goroutine touches it.
+var sessionCounters = map[string]map[string]db.PeerCounter{}
+
+// TrackSessions derives connection sessions from the interface: a peer with a handshake within
+// wireguard.OnlineWindow is connected; a session opens on its first such handshake, ends when the
+// handshakes stop or the peer is removed, and is split when the peer roams to another endpoint.
+// Sessions left open by a previous run are continued if the peer is still connected from the same endpoint.
+func TrackSessions(cfg *config.Config, now time.Time) error {
+	var open []db.PeerSession
+	if err := db.DB.Where("ended_at IS NULL AND interface = ?", cfg.WGInterface).Find(&open).Error; err != nil {
+		return err
+	}
+	openByPeer := map[string]*db.PeerSession{}
+	for i := range open {
+		openByPeer[open[i].PeerUUID] = &open[i]
+	}
+
+	var runtime []wireguard.PeerDump
+	if wireguard.InterfaceUp(cfg) {
+		dump, err := wireguard.ShowDump(cfg)
+		if err != nil {
+			return err
+		}
+		runtime = dump.Peers
+	}
+	var peers []db.Peer
+	if err := db.DB.Select("uuid", "public_key").Find(&peers).Error; err != nil {
+		return err
+	}
+	byKey := map[string]string{}
+	for _, p := range peers {
+		byKey[p.PublicKey] = p.UUID
+	}
+	counters := sessionCounters[cfg.WGInterface]
+	if counters == nil {
+		counters = map[string]db.PeerCounter{}
+		sessionCounters[cfg.WGInterface] = counters
+	}
+
+	var closed, updated, opened []db.PeerSession
+	seen := map[string]bool{}
+	for _, rt := range runtime {
+		uuid, ok := byKey[rt.PublicKey]
+		if !ok {
+			continue
+		}
+		seen[uuid] = true
+		var rx, tx int64
+		if prev, ok := counters[uuid]; ok {
+			rx, tx = rt.RxBytes, rt.TxBytes
+			if prev.PublicKey == rt.PublicKey && rx >= prev.RxBytes && tx >= prev.TxBytes {
+				rx -= prev.RxBytes
+				tx -= prev.TxBytes
+			}
+		}
+		counters[uuid] = db.PeerCounter{PeerUUID: uuid, PublicKey: rt.PublicKey, RxBytes: rt.RxBytes, TxBytes: rt.TxBytes}
+
+		s := openByPeer[uuid]
+		connected := rt.Online(now)
+		if s != nil && connected && s.Endpoint != rt.Endpoint {
+			// Roamed: the old session ended with its last handshake, the new one starts with this one
+			closed = append(closed, endSession(*s))
+			s = nil
+		}
+		switch {
+		case s != nil:
+			s.RxBytes += rx
+			s.TxBytes += tx
+			if rt.LatestHandshake != nil && rt.LatestHandshake.After(s.LastHandshakeAt) {
+				s.LastHandshakeAt = *rt.LatestHandshake
+			}
+			if connected {
+				updated = append(updated, *s)
+			} else {
+				closed = append(closed, endSession(*s))
+			}
+		case connected:
+			opened = append(opened, db.PeerSession{
+				PeerUUID:        uuid,
+				Interface:       cfg.WGInterface,
+				Endpoint:        rt.Endpoint,
+				StartedAt:       *rt.LatestHandshake,
+				LastHandshakeAt: *rt.LatestHandshake,
+				RxBytes:         rx,
+				TxBytes:         tx,
+			})
+		}
+	}
+	// Peers gone from the interface (removed, disabled, or the interface is down) are disconnected
+	for uuid, s := range openByPeer {
+		if !seen[uuid] {
+			closed = append(closed, endSession(*s))
+		}
+	}
+	for uuid := range counters {
+		if !seen[uuid] {
+			delete(counters, uuid)
+		}
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, s := range append(closed, updated...) {
+			err := tx.Model(&db.PeerSession{}).Where("id = ?", s.ID).Updates(map[string]any{
+				"last_handshake_at": s.LastHandshakeAt,
+				"ended_at":          s.EndedAt,
+				"rx_bytes":          s.RxBytes,
+				"tx_bytes":          s.TxBytes,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(opened) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).Omit("id", "peer_name").Create(&opened).Error
+	})
+}
+
+func endSession(s db.PeerSession) db.PeerSession {
+	end := s.LastHandshakeAt
+	s.EndedAt = &end
+	return s
+}
+
+// PruneSessions deletes sessions that ended more than SESSION_RETENTION_DAYS ago.
+func PruneSessions(cfg *config.Config, now time.Time) error {
+	cutoff := now.AddDate(0, 0, -atoi(cfg.SessionRetention, 365))
+	return db.DB.Where("ended_at < ?", cutoff).Delete(&db.PeerSession{}).Error
+}
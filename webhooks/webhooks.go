@@ -0,0 +1,252 @@
+// Package webhooks delivers events from the event bus to the configured webhooks, signed with each
+// webhook's secret and retried with backoff. Deliveries live in the database, so pending retries
+// survive a restart.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+)
+
+// TestEvent is the type of the event sent by SendTest; it never appears on the event bus.
+const TestEvent = "webhook.test"
+
+// backoff is the wait before each retry; a delivery is given up after the last one.
+var backoff = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+const (
+	requestTimeout = 10 * time.Second
+	// pollInterval is how often due retries are looked for when no new event arrives
+	pollInterval = 5 * time.Second
+	batchSize    = 50
+	// retention is how long finished deliveries are kept in the log
+	retention = 30 * 24 * time.Hour
+)
+
+var (
+	client = &http.Client{Timeout: requestTimeout}
+	wake   = make(chan struct{}, 1)
+)
+
+// Run queues a delivery for every event that matches an enabled webhook and sends due deliveries
+// until the process exits.
+func Run() {
+	go dispatch()
+	var last int64
+	for {
+		ch, cancel := events.Subscribe(nil, last)
+		for ev := range ch {
+			last = ev.ID
+			if err := enqueue(ev); err != nil {
+				log.Printf("[WG] queue webhook deliveries for event %d failed: %v", ev.ID, err)
+			}
+		}
+		cancel()
+		// Dropped for falling behind; resubscribing replays what is still in the history
+		log.Printf("[WG] webhooks fell behind the event stream, resuming after event %d", last)
+	}
+}
+
+func enqueue(ev events.Event) error {
+	var hooks []db.Webhook
+	if err := db.DB.Where("enabled").Find(&hooks).Error; err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	var queued []db.WebhookDelivery
+	for _, h := range hooks {
+		if events.Match(h.Events, ev.Type) {
+			queued = append(queued, newDelivery(h, ev, payload))
+		}
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	if err := db.DB.Create(&queued).Error; err != nil {
+		return err
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func newDelivery(h db.Webhook, ev events.Event, payload []byte) db.WebhookDelivery {
+	now := time.Now()
+	return db.WebhookDelivery{
+		WebhookUUID:   h.UUID,
+		EventID:       ev.ID,
+		EventType:     ev.Type,
+		Payload:       payload,
+		CreatedAt:     now,
+		Status:        db.DeliveryPending,
+		NextAttemptAt: &now,
+	}
+}
+
+func dispatch() {
+	var lastPrune time.Time
+	for {
+		now := time.Now()
+		if err := deliverDue(now); err != nil {
+			log.Printf("[WG] webhook delivery failed: %v", err)
+		}
+		if now.Sub(lastPrune) >= time.Hour {
+			err := db.DB.Where("status <> ? AND created_at < ?", db.DeliveryPending, now.Add(-retention)).Delete(&db.WebhookDelivery{}).Error
+			if err != nil {
+				log.Printf("[WG] prune webhook deliveries failed: %v", err)
+			} else {
+				lastPrune = now
+			}
+		}
+		select {
+		case <-wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// deliverDue sends the deliveries whose next attempt is due. Webhooks are served in parallel so that
+// a slow or dead endpoint does not hold up the others; each webhook gets its deliveries in order.
+func deliverDue(now time.Time) error {
+	var due []db.WebhookDelivery
+	if err := db.DB.Where("status = ? AND next_attempt_at <= ?", db.DeliveryPending, now).Order("id").Limit(batchSize).Find(&due).Error; err != nil {
+		return err
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	byHook := map[string][]db.WebhookDelivery{}
+	var uuids []string
+	for _, d := range due {
+		if _, ok := byHook[d.WebhookUUID]; !ok {
+			uuids = append(uuids, d.WebhookUUID)
+		}
+		byHook[d.WebhookUUID] = append(byHook[d.WebhookUUID], d)
+	}
+	var hooks []db.Webhook
+	if err := db.DB.Where("uuid IN ?", uuids).Find(&hooks).Error; err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, h := range hooks {
+		wg.Add(1)
+		go func(h db.Webhook, queue []db.WebhookDelivery) {
+			defer wg.Done()
+			for _, d := range queue {
+				attempt(h, &d, true)
+			}
+		}(h, byHook[h.UUID])
+	}
+	wg.Wait()
+	return nil
+}
+
+// attempt sends a delivery once and saves the outcome. With retry, a failure is rescheduled
+// according to backoff until the attempts run out.
+func attempt(h db.Webhook, d *db.WebhookDelivery, retry bool) {
+	now := time.Now()
+	d.Attempts++
+	d.NextAttemptAt = nil
+	var code int
+	var err error
+	if retry && !h.Enabled {
+		// Disabled since the event was queued; give up rather than flood the endpoint once it is re-enabled
+		err = fmt.Errorf("webhook disabled")
+		retry = false
+	} else {
+		code, err = send(h, d)
+	}
+	if code != 0 {
+		d.ResponseCode = &code
+	}
+	switch {
+	case err == nil:
+		d.Status = db.DeliveryDelivered
+		d.DeliveredAt = &now
+		d.Error = nil
+	case retry && d.Attempts <= len(backoff):
+		next := now.Add(backoff[d.Attempts-1])
+		d.Status = db.DeliveryPending
+		d.NextAttemptAt = &next
+		msg := err.Error()
+		d.Error = &msg
+	default:
+		d.Status = db.DeliveryFailed
+		msg := err.Error()
+		d.Error = &msg
+	}
+	err = db.DB.Model(d).Select("status", "attempts", "next_attempt_at", "response_code", "error", "delivered_at").Updates(d).Error
+	if err != nil {
+		log.Printf("[WG] save webhook delivery %d failed: %v", d.ID, err)
+	}
+}
+
+func send(h db.Webhook, d *db.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	ts := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wireguard-ui-webhook")
+	req.Header.Set("X-WGUI-Event", d.EventType)
+	req.Header.Set("X-WGUI-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-WGUI-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-WGUI-Signature", Sign(h.Secret, ts, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the X-WGUI-Signature header value: the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the webhook secret. Receivers should recompute it and reject stale timestamps.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendTest sends a webhook.test event to h right away, even when it is disabled, without retries,
+// and logs it like any other delivery.
+func SendTest(h db.Webhook, actor string) (db.WebhookDelivery, error) {
+	ev := events.Event{Type: TestEvent, Time: time.Now(), Actor: actor, Data: map[string]any{"webhook": h.Name}}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return db.WebhookDelivery{}, err
+	}
+	d := newDelivery(h, ev, payload)
+	// Not due for the dispatcher: it is sent here
+	d.NextAttemptAt = nil
+	if err := db.DB.Create(&d).Error; err != nil {
+		return d, err
+	}
+	attempt(h, &d, false)
+	return d, nil
+}
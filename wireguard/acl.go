@@ -0,0 +1,229 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+)
+
+// ACLChain is the filter chain that forwarded tunnel traffic is sent through.
+func ACLChain(cfg *config.Config) string {
+	return "WGUI-" + cfg.WGInterface
+}
+
+// ACLScriptPath is the shell script that installs (up) or removes (down) the chain; wg-quick runs it from PostUp/PostDown.
+func ACLScriptPath(cfg *config.Config) string {
+	return filepath.Join(cfg.WGConfDir, cfg.WGInterface+"-acl.sh")
+}
+
+// renderACLScript loads the rules and groups and renders the ACL script for the given peers.
+func renderACLScript(cfg *config.Config, peers []db.Peer) (string, error) {
+	var rules []db.ACLRule
+	if err := db.DB.Order("position, created_at").Find(&rules).Error; err != nil {
+		return "", fmt.Errorf("load acl rules: %w", err)
+	}
+	var groups []db.PeerGroup
+	if err := db.DB.Find(&groups).Error; err != nil {
+		return "", fmt.Errorf("load groups: %w", err)
+	}
+	byUUID := map[string]*db.PeerGroup{}
+	for i := range groups {
+		byUUID[groups[i].UUID] = &groups[i]
+	}
+	// Callers do not always preload groups; the inherited firewall settings need them
+	withGroups := make([]db.Peer, len(peers))
+	for i, p := range peers {
+		if p.GroupUUID != nil {
+			p.Group = byUUID[*p.GroupUUID]
+		}
+		withGroups[i] = p
+	}
+	return RenderACLScript(cfg.WGInterface, ACLChain(cfg), withGroups, rules), nil
+}
+
+// RenderACLScript compiles isolation flags and ACL rules of the active peers into a POSIX shell script.
+//
+// Traffic arriving from outside the tunnel keeps being forwarded as before. Traffic sent by a peer is
+// checked in this order: established flows, isolation, the peer's own rules, its group's rules, and
+// finally the peer's ACL default (accept unless set to drop).
+func RenderACLScript(iface, chain string, peers []db.Peer, rules []db.ACLRule) string {
+	byPeer := map[string][]db.ACLRule{}
+	byGroup := map[string][]db.ACLRule{}
+	for _, r := range rules {
+		if r.PeerUUID != nil {
+			byPeer[*r.PeerUUID] = append(byPeer[*r.PeerUUID], r)
+		} else if r.GroupUUID != nil {
+			byGroup[*r.GroupUUID] = append(byGroup[*r.GroupUUID], r)
+		}
+	}
+
+	v4 := &aclFamily{chain: chain}
+	v6 := &aclFamily{chain: chain, v6: true}
+	for _, f := range []*aclFamily{v4, v6} {
+		f.add("-m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT")
+		f.add(fmt.Sprintf("! -i %s -j ACCEPT", iface))
+	}
+
+	now := time.Now()
+	var active []db.Peer
+	for _, p := range peers {
+		if p.Active(now) {
+			active = append(active, p)
+		}
+	}
+	// Isolation comes before any rule so that a broad accept cannot reopen peer-to-peer traffic
+	for _, p := range active {
+		if !p.Settings().Isolated {
+			continue
+		}
+		for _, src := range peerAddrs(p) {
+			fam := v4
+			if src.Addr().Is6() {
+				fam = v6
+			}
+			fam.add(fmt.Sprintf("-o %s -s %s -j DROP", iface, src))
+			fam.add(fmt.Sprintf("-o %s -d %s -j DROP", iface, src))
+		}
+	}
+	for _, p := range active {
+		peerRules := append([]db.ACLRule{}, byPeer[p.UUID]...)
+		if p.GroupUUID != nil {
+			peerRules = append(peerRules, byGroup[*p.GroupUUID]...)
+		}
+		for _, src := range peerAddrs(p) {
+			fam := v4
+			if src.Addr().Is6() {
+				fam = v6
+			}
+			for _, r := range peerRules {
+				fam.addRule(src, r)
+			}
+			if p.Settings().ACLDefault == db.ACLDrop {
+				fam.add(fmt.Sprintf("-s %s -j DROP", src))
+			}
+		}
+	}
+	for _, f := range []*aclFamily{v4, v6} {
+		f.add("-j ACCEPT")
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by wireguard-ui from the peer ACLs in the database; manual changes are overwritten.\n")
+	fmt.Fprintf(&b, "# Usage: %s-acl.sh up|down\n", iface)
+	fmt.Fprintf(&b, "IFACE=%s\n", iface)
+	fmt.Fprintf(&b, "CHAIN=%s\n\n", chain)
+
+	b.WriteString("down() {\n")
+	b.WriteString("\tfor ipt in iptables ip6tables; do\n")
+	b.WriteString("\t\twhile $ipt -w -D FORWARD -i \"$IFACE\" -j \"$CHAIN\" 2>/dev/null; do :; done\n")
+	b.WriteString("\t\twhile $ipt -w -D FORWARD -o \"$IFACE\" -j \"$CHAIN\" 2>/dev/null; do :; done\n")
+	b.WriteString("\t\t$ipt -w -F \"$CHAIN\" 2>/dev/null\n")
+	b.WriteString("\t\t$ipt -w -X \"$CHAIN\" 2>/dev/null\n")
+	b.WriteString("\tdone\n")
+	b.WriteString("\treturn 0\n")
+	b.WriteString("}\n\n")
+
+	// iptables-restore --noflush replaces the chain contents atomically, so reapplying never opens a gap
+	b.WriteString("up() {\n")
+	b.WriteString("\tiptables-restore -w --noflush <<'EOF' || return 1\n")
+	b.WriteString(v4.String())
+	b.WriteString("EOF\n")
+	b.WriteString("\tip6tables-restore -w --noflush <<'EOF' || echo \"wireguard-ui: IPv6 ACLs not applied\" >&2\n")
+	b.WriteString(v6.String())
+	b.WriteString("EOF\n")
+	b.WriteString("\tfor ipt in iptables ip6tables; do\n")
+	b.WriteString("\t\t$ipt -w -C FORWARD -o \"$IFACE\" -j \"$CHAIN\" 2>/dev/null || $ipt -w -I FORWARD 1 -o \"$IFACE\" -j \"$CHAIN\"\n")
+	b.WriteString("\t\t$ipt -w -C FORWARD -i \"$IFACE\" -j \"$CHAIN\" 2>/dev/null || $ipt -w -I FORWARD 1 -i \"$IFACE\" -j \"$CHAIN\"\n")
+	b.WriteString("\tdone\n")
+	b.WriteString("\treturn 0\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("case \"$1\" in\n")
+	b.WriteString("up) up ;;\n")
+	b.WriteString("down) down ;;\n")
+	b.WriteString("*) echo \"usage: $0 up|down\" >&2; exit 2 ;;\n")
+	b.WriteString("esac\n")
+	return b.String()
+}
+
+// aclFamily collects the chain rules of one address family in iptables-restore syntax.
+type aclFamily struct {
+	chain string
+	v6    bool
+	lines []string
+}
+
+func (f *aclFamily) add(spec string) {
+	f.lines = append(f.lines, fmt.Sprintf("-A %s %s", f.chain, spec))
+}
+
+// addRule emits r for traffic from src, skipping rules whose destination is of the other family.
+func (f *aclFamily) addRule(src netip.Prefix, r db.ACLRule) {
+	spec := fmt.Sprintf("-s %s", src)
+	if r.Destination != nil && *r.Destination != "" {
+		dst, err := netip.ParsePrefix(*r.Destination)
+		if err != nil || dst.Addr().Is6() != f.v6 {
+			return
+		}
+		spec += fmt.Sprintf(" -d %s", dst)
+	}
+	switch r.Protocol {
+	case "tcp", "udp":
+		spec += " -p " + r.Protocol
+		if r.Ports != nil && *r.Ports != "" {
+			spec += " -m multiport --dports " + strings.ReplaceAll(*r.Ports, "-", ":")
+		}
+	case "icmp":
+		if f.v6 {
+			spec += " -p ipv6-icmp"
+		} else {
+			spec += " -p icmp"
+		}
+	}
+	if r.Action == db.ACLDrop {
+		spec += " -j DROP"
+	} else {
+		spec += " -j ACCEPT"
+	}
+	f.add(spec)
+}
+
+func (f *aclFamily) String() string {
+	var b strings.Builder
+	b.WriteString("*filter\n")
+	// Declaring an existing user chain under --noflush empties it first
+	fmt.Fprintf(&b, ":%s - [0:0]\n", f.chain)
+	for _, l := range f.lines {
+		b.WriteString(l + "\n")
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+// peerAddrs returns the tunnel addresses of a peer.
+func peerAddrs(p db.Peer) []netip.Prefix {
+	var out []netip.Prefix
+	for _, a := range []*string{p.IPv4, p.IPv6} {
+		if a == nil {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(*a); err == nil {
+			out = append(out, prefix)
+		}
+	}
+	return out
+}
+
+// ApplyACL reloads the ACL chain on the running interface.
+func ApplyACL(cfg *config.Config) error {
+	if err := reloadScript(cfg, "acl", ACLScriptPath(cfg)); err != nil {
+		return fmt.Errorf("apply acl: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/events"
+)
+
+const (
+	ApplyAuto   = "auto"
+	ApplyManual = "manual"
+)
+
+// Reload selects what Apply loads into the running interface.
+type Reload struct {
+	Peers   bool // the [Peer] sections, private key and listen port, with `wg syncconf`
+	ACL     bool
+	Shaping bool
+	// Revoke lists public keys that lost access. They leave the running interface right away with
+	// RemovePeer, in either WG_APPLY_MODE; only additions and updates wait for a manual apply.
+	Revoke []string
+}
+
+// ReloadAll loads everything the config files describe.
+var ReloadAll = Reload{Peers: true, ACL: true, Shaping: true}
+
+// ApplyResult is what happened to the running interface after its config files were rewritten.
+type ApplyResult struct {
+	Applied bool
+	// Pending is set when the change waits for a manual apply (WG_APPLY_MODE=manual) or the node agent
+	Pending bool
+	Error   string
+}
+
+// Apply loads the rewritten config files into cfg's running interface without restarting it. A down
+// interface is left alone, since wg-quick loads the files on the next start; a remote one is applied
+// by its node agent on the next sync.
+func Apply(cfg *config.Config, r Reload) ApplyResult {
+	if cfg.Remote() {
+		return ApplyResult{Pending: InterfaceUp(cfg)}
+	}
+	if !InterfaceUp(cfg) {
+		return ApplyResult{}
+	}
+	if r.Peers {
+		if err := SyncConf(cfg); err != nil {
+			return ApplyResult{Error: err.Error()}
+		}
+		events.Publish(events.Event{Type: events.ConfigApplied, Interface: cfg.WGInterface, Data: map[string]any{"kind": "peers"}})
+	}
+	if r.ACL {
+		if err := ApplyACL(cfg); err != nil {
+			return ApplyResult{Error: err.Error()}
+		}
+	}
+	if r.Shaping {
+		if err := ApplyShaping(cfg); err != nil {
+			return ApplyResult{Error: err.Error()}
+		}
+	}
+	return ApplyResult{Applied: true}
+}
+
+// ApplyChanges is Apply as WG_APPLY_MODE asks for: in manual mode the running interface is left alone
+// and the change reported as pending, except for the revoked keys, which are removed in either mode.
+func ApplyChanges(cfg *config.Config, r Reload) ApplyResult {
+	if cfg.Remote() || !InterfaceUp(cfg) {
+		return Apply(cfg, r)
+	}
+	var revokeErr error
+	for _, key := range r.Revoke {
+		if err := RemovePeer(cfg, key); err != nil && revokeErr == nil {
+			revokeErr = fmt.Errorf("revoke peer: %w", err)
+		}
+	}
+	res := ApplyResult{Pending: true}
+	if cfg.WGApplyMode != ApplyManual {
+		res = Apply(cfg, r)
+	}
+	if revokeErr != nil && res.Error == "" {
+		res.Error = revokeErr.Error()
+		res.Applied = false
+	}
+	return res
+}
+
+// ApplyAllChanges is ApplyChanges on every managed interface, e.g. after a change to group or global ACL
+// rules. The result is applied only when every running interface was, pending when any waits, and carries
+// the first error.
+func ApplyAllChanges(cfg *config.Config, r Reload) ApplyResult {
+	ifaces, err := Interfaces(cfg)
+	if err != nil {
+		return ApplyResult{Error: err.Error()}
+	}
+	var out ApplyResult
+	for _, ifc := range ifaces {
+		res := ApplyChanges(ifc.Cfg, r)
+		out.Applied = out.Applied || res.Applied
+		out.Pending = out.Pending || res.Pending
+		if res.Error != "" && out.Error == "" {
+			out.Error = fmt.Sprintf("%s: %s", ifc.Cfg.WGInterface, res.Error)
+		}
+	}
+	out.Applied = out.Applied && !out.Pending && out.Error == ""
+	return out
+}
+
+// NeedsRestart reports whether going from one server config to another changes more than `wg syncconf`
+// can load: the [Interface] lines other than PrivateKey and ListenPort, such as Address or PostUp.
+func NeedsRestart(old, new string) bool {
+	return interfaceLines(old) != interfaceLines(new)
+}
+
+func interfaceLines(conf string) string {
+	var b strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(conf))
+	section := ""
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		key, _, _ := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if section != "[Interface]" || line == "" || key == "PrivateKey" || key == "ListenPort" {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
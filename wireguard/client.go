@@ -0,0 +1,76 @@
+package wireguard
+
+import (
+	"strings"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+)
+
+// ClientConfig is the format-independent content of a peer's client configuration.
+// Every export format is rendered from it.
+type ClientConfig struct {
+	Name                string   `json:"name,omitempty"`
+	PrivateKey          string   `json:"private_key"` // PrivateKeyPlaceholder for bring-your-own-key peers
+	Addresses           []string `json:"addresses"`
+	DNS                 []string `json:"dns,omitempty"`
+	MTU                 int      `json:"mtu"`
+	ServerPublicKey     string   `json:"server_public_key"`
+	PresharedKey        string   `json:"preshared_key,omitempty"`
+	Endpoint            string   `json:"endpoint"`
+	EndpointHost        string   `json:"endpoint_host"`
+	EndpointPort        int      `json:"endpoint_port"`
+	AllowedIPs          []string `json:"allowed_ips"`
+	PersistentKeepalive int      `json:"persistent_keepalive,omitempty"`
+}
+
+// BuildClientConfig resolves a peer's client configuration, applying group inheritance
+// (p.Group must be preloaded) and the built-in defaults.
+func BuildClientConfig(s db.Server, p db.Peer) ClientConfig {
+	cc := ClientConfig{
+		Name:            valOrEmpty(p.Name),
+		PrivateKey:      PrivateKeyPlaceholder,
+		ServerPublicKey: s.PublicKey,
+		Endpoint:        Endpoint(s),
+		EndpointHost:    s.PublicIP,
+		EndpointPort:    s.Port,
+		MTU:             DefaultMTU,
+	}
+	// The server never saw a bring-your-own-key peer's private key; the client fills it in
+	if p.PrivateKey != nil && *p.PrivateKey != "" {
+		cc.PrivateKey = *p.PrivateKey
+	}
+	if p.PresharedKey != nil {
+		cc.PresharedKey = *p.PresharedKey
+	}
+	// Address: include IPv4 and optionally IPv6
+	cc.Addresses = []string{valOrEmpty(p.IPv4)}
+	if s.EnableIPv6 && p.IPv6 != nil && *p.IPv6 != "" {
+		cc.Addresses = append(cc.Addresses, *p.IPv6)
+	}
+
+	st := p.Settings()
+	cc.DNS = splitList(st.DNS)
+	if st.MTU > 0 {
+		cc.MTU = st.MTU
+	}
+	cc.PersistentKeepalive = st.PersistentKeepalive
+	// Route only the server subnets through the tunnel by default; rely on server-side NAT
+	if st.AllowedIPs != "" {
+		cc.AllowedIPs = splitList(st.AllowedIPs)
+	} else if s.EnableIPv6 && s.SubnetV6 != "" {
+		cc.AllowedIPs = []string{s.SubnetV4, s.SubnetV6}
+	} else {
+		cc.AllowedIPs = []string{s.SubnetV4}
+	}
+	return cc
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
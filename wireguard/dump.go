@@ -0,0 +1,104 @@
+package wireguard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+)
+
+// OnlineWindow is how recent a handshake must be for a peer to count as online. WireGuard rekeys
+// every two minutes while traffic flows and drops a session after three, so an older handshake means
+// the peer is gone (or idle without a persistent keepalive).
+const OnlineWindow = 3 * time.Minute
+
+// Dump is the parsed output of `wg show <iface> dump`.
+type Dump struct {
+	PublicKey  string
+	ListenPort int
+	FwMark     string
+	Peers      []PeerDump
+}
+
+// PeerDump is the runtime state of one peer on the interface.
+type PeerDump struct {
+	PublicKey  string
+	Endpoint   string // empty until the peer has connected
+	AllowedIPs []string
+	// LatestHandshake is nil when the peer has never completed a handshake
+	LatestHandshake     *time.Time
+	RxBytes             int64
+	TxBytes             int64
+	PersistentKeepalive int // seconds; 0 when off
+}
+
+// Online reports whether the peer completed a handshake within OnlineWindow.
+func (p PeerDump) Online(now time.Time) bool {
+	return p.LatestHandshake != nil && now.Sub(*p.LatestHandshake) < OnlineWindow
+}
+
+// ShowDump reads the runtime state of the configured interface.
+func ShowDump(cfg *config.Config) (*Dump, error) {
+	if cfg.Remote() {
+		return remoteDump(cfg)
+	}
+	out, err := runWG(cfg, "", "show", cfg.WGInterface, "dump")
+	if err != nil {
+		return nil, err
+	}
+	return ParseDump(out)
+}
+
+// ParseDump parses the tab-separated `wg show <iface> dump` format: one interface line
+// (private key, public key, listen port, fwmark) followed by one line per peer
+// (public key, preshared key, endpoint, allowed ips, latest handshake, rx, tx, persistent keepalive).
+// Extra trailing fields, as printed by some wg forks, are ignored.
+func ParseDump(out string) (*Dump, error) {
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		return nil, fmt.Errorf("empty dump")
+	}
+	f := strings.Split(lines[0], "\t")
+	if len(f) < 4 {
+		return nil, fmt.Errorf("malformed interface line: %d fields", len(f))
+	}
+	d := &Dump{PublicKey: f[1]}
+	d.ListenPort, _ = strconv.Atoi(f[2])
+	if f[3] != "off" {
+		d.FwMark = f[3]
+	}
+	for i, line := range lines[1:] {
+		f := strings.Split(line, "\t")
+		if len(f) < 8 {
+			return nil, fmt.Errorf("malformed peer line %d: %d fields", i+1, len(f))
+		}
+		p := PeerDump{PublicKey: f[0]}
+		if f[2] != "(none)" {
+			p.Endpoint = f[2]
+		}
+		if f[3] != "(none)" && f[3] != "" {
+			p.AllowedIPs = strings.Split(f[3], ",")
+		}
+		hs, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("peer line %d: latest handshake: %v", i+1, err)
+		}
+		if hs > 0 {
+			t := time.Unix(hs, 0)
+			p.LatestHandshake = &t
+		}
+		if p.RxBytes, err = strconv.ParseInt(f[5], 10, 64); err != nil {
+			return nil, fmt.Errorf("peer line %d: rx bytes: %v", i+1, err)
+		}
+		if p.TxBytes, err = strconv.ParseInt(f[6], 10, 64); err != nil {
+			return nil, fmt.Errorf("peer line %d: tx bytes: %v", i+1, err)
+		}
+		if f[7] != "off" {
+			p.PersistentKeepalive, _ = strconv.Atoi(f[7])
+		}
+		d.Peers = append(d.Peers, p)
+	}
+	return d, nil
+}
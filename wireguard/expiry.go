@@ -0,0 +1,64 @@
+package wireguard
+
+import (
+	"log"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+)
+
+// WatchExpiry periodically drops peers whose expiry time has passed from the server configuration
+// and from the running interface. The first pass also catches peers that expired while the service was down.
+func WatchExpiry(cfg *config.Config, interval time.Duration) {
+	var last time.Time
+	for {
+		now := time.Now()
+		var expired []db.Peer
+		if err := db.DB.Where("enabled AND expires_at > ? AND expires_at <= ?", last, now).Find(&expired).Error; err != nil {
+			log.Printf("[WG] expiry check failed: %v", err)
+		} else {
+			last = now
+			if len(expired) > 0 {
+				expirePeers(cfg, expired)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// expirePeers rewrites the configs of the interfaces the expired peers belong to and removes them from
+// the running interfaces.
+func expirePeers(cfg *config.Config, expired []db.Peer) {
+	ifaces, err := Interfaces(cfg)
+	if err != nil {
+		log.Printf("[WG] expiry: query interfaces failed: %v", err)
+		return
+	}
+	for _, ifc := range ifaces {
+		var mine []db.Peer
+		for _, p := range expired {
+			if p.ServerUUID == ifc.Server.UUID {
+				mine = append(mine, p)
+			}
+		}
+		if len(mine) == 0 {
+			continue
+		}
+		if err := WriteInterfaceConfigs(ifc.Cfg, ifc.Server); err != nil {
+			log.Printf("[WG] regenerate configs after expiry failed: %v", err)
+		}
+		if InterfaceUp(ifc.Cfg) {
+			for _, p := range mine {
+				if err := RemovePeer(ifc.Cfg, p.PublicKey); err != nil {
+					log.Printf("[WG] remove expired peer %s failed: %v", p.UUID, err)
+				}
+			}
+		}
+		for _, p := range mine {
+			events.Publish(events.Event{Type: events.PeerExpired, Interface: ifc.Server.Interface, PeerUUID: p.UUID, Data: map[string]any{"name": p.Name, "expires_at": p.ExpiresAt}})
+		}
+	}
+	log.Printf("[WG] %d peer(s) expired", len(expired))
+}
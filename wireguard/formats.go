@@ -0,0 +1,268 @@
+package wireguard
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/StellaShiina/wireguard-ui/db"
+)
+
+// Client config export formats accepted by Export.
+const (
+	FormatWGQuick        = "wg-quick"
+	FormatNetworkManager = "networkmanager"
+	FormatNetworkd       = "networkd"
+	FormatRouterOS       = "routeros"
+	FormatOpenWrt        = "openwrt"
+	FormatJSON           = "json"
+)
+
+// Formats lists every export format, wg-quick first.
+var Formats = []string{FormatWGQuick, FormatNetworkManager, FormatNetworkd, FormatRouterOS, FormatOpenWrt, FormatJSON}
+
+// ValidFormat reports whether Export understands format.
+func ValidFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// clientInterface is the interface name used on the client side by formats that need one.
+const clientInterface = "wg0"
+
+// Export renders a peer's client config in the given format and returns a suggested filename,
+// the content type and the content.
+func Export(s db.Server, p db.Peer, format string) (filename, contentType string, data []byte, err error) {
+	cc := BuildClientConfig(s, p)
+	switch format {
+	case "", FormatWGQuick:
+		return p.UUID + ".conf", "text/plain; charset=utf-8", []byte(RenderPeerConfig(s, p)), nil
+	case FormatNetworkManager:
+		return p.UUID + ".nmconnection", "text/plain; charset=utf-8", []byte(renderNetworkManager(cc, p.UUID)), nil
+	case FormatNetworkd:
+		data, err := renderNetworkd(cc)
+		return p.UUID + "-networkd.zip", "application/zip", data, err
+	case FormatRouterOS:
+		return p.UUID + ".rsc", "text/plain; charset=utf-8", []byte(renderRouterOS(cc)), nil
+	case FormatOpenWrt:
+		return p.UUID + ".uci", "text/plain; charset=utf-8", []byte(renderOpenWrt(cc)), nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(cc, "", "  ")
+		return p.UUID + ".json", "application/json", append(data, '\n'), err
+	default:
+		return "", "", nil, fmt.Errorf("unknown format %q (expected %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// splitAddrs separates IPv4 and IPv6 prefixes.
+func splitAddrs(addrs []string) (v4, v6 []string) {
+	for _, a := range addrs {
+		if prefix, err := netip.ParsePrefix(a); err == nil && prefix.Addr().Is6() {
+			v6 = append(v6, a)
+		} else {
+			v4 = append(v4, a)
+		}
+	}
+	return v4, v6
+}
+
+// renderNetworkManager produces a NetworkManager keyfile (/etc/NetworkManager/system-connections/*.nmconnection, mode 0600).
+func renderNetworkManager(cc ClientConfig, uuid string) string {
+	id := cc.Name
+	if id == "" {
+		id = "wireguard-" + uuid[:8]
+	}
+	var b strings.Builder
+	b.WriteString("[connection]\n")
+	fmt.Fprintf(&b, "id=%s\n", id)
+	fmt.Fprintf(&b, "uuid=%s\n", uuid)
+	b.WriteString("type=wireguard\n")
+	fmt.Fprintf(&b, "interface-name=%s\n\n", clientInterface)
+
+	b.WriteString("[wireguard]\n")
+	fmt.Fprintf(&b, "private-key=%s\n", cc.PrivateKey)
+	fmt.Fprintf(&b, "mtu=%d\n\n", cc.MTU)
+
+	fmt.Fprintf(&b, "[wireguard-peer.%s]\n", cc.ServerPublicKey)
+	fmt.Fprintf(&b, "endpoint=%s\n", cc.Endpoint)
+	if cc.PresharedKey != "" {
+		fmt.Fprintf(&b, "preshared-key=%s\n", cc.PresharedKey)
+		b.WriteString("preshared-key-flags=0\n")
+	}
+	if cc.PersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "persistent-keepalive=%d\n", cc.PersistentKeepalive)
+	}
+	fmt.Fprintf(&b, "allowed-ips=%s;\n\n", strings.Join(cc.AllowedIPs, ";"))
+
+	v4, v6 := splitAddrs(cc.Addresses)
+	dns4, dns6 := splitDNS(cc.DNS)
+	b.WriteString("[ipv4]\n")
+	for i, a := range v4 {
+		fmt.Fprintf(&b, "address%d=%s\n", i+1, a)
+	}
+	if len(dns4) > 0 {
+		fmt.Fprintf(&b, "dns=%s;\n", strings.Join(dns4, ";"))
+	}
+	b.WriteString("method=manual\n\n")
+	b.WriteString("[ipv6]\n")
+	if len(v6) == 0 {
+		b.WriteString("method=disabled\n")
+		return b.String()
+	}
+	for i, a := range v6 {
+		fmt.Fprintf(&b, "address%d=%s\n", i+1, a)
+	}
+	if len(dns6) > 0 {
+		fmt.Fprintf(&b, "dns=%s;\n", strings.Join(dns6, ";"))
+	}
+	b.WriteString("addr-gen-mode=default\n")
+	b.WriteString("method=manual\n")
+	return b.String()
+}
+
+// splitDNS separates resolver addresses by family; search domains are dropped since keyfiles keep them elsewhere.
+func splitDNS(dns []string) (v4, v6 []string) {
+	for _, d := range dns {
+		addr, err := netip.ParseAddr(d)
+		switch {
+		case err != nil:
+		case addr.Is4():
+			v4 = append(v4, d)
+		default:
+			v6 = append(v6, d)
+		}
+	}
+	return v4, v6
+}
+
+// renderNetworkd produces a zip with wg0.netdev and wg0.network for /etc/systemd/network.
+func renderNetworkd(cc ClientConfig) ([]byte, error) {
+	var netdev strings.Builder
+	netdev.WriteString("[NetDev]\n")
+	fmt.Fprintf(&netdev, "Name=%s\n", clientInterface)
+	netdev.WriteString("Kind=wireguard\n")
+	fmt.Fprintf(&netdev, "MTUBytes=%d\n\n", cc.MTU)
+	netdev.WriteString("[WireGuard]\n")
+	fmt.Fprintf(&netdev, "PrivateKey=%s\n\n", cc.PrivateKey)
+	netdev.WriteString("[WireGuardPeer]\n")
+	fmt.Fprintf(&netdev, "PublicKey=%s\n", cc.ServerPublicKey)
+	if cc.PresharedKey != "" {
+		fmt.Fprintf(&netdev, "PresharedKey=%s\n", cc.PresharedKey)
+	}
+	fmt.Fprintf(&netdev, "Endpoint=%s\n", cc.Endpoint)
+	fmt.Fprintf(&netdev, "AllowedIPs=%s\n", strings.Join(cc.AllowedIPs, ","))
+	if cc.PersistentKeepalive > 0 {
+		fmt.Fprintf(&netdev, "PersistentKeepalive=%d\n", cc.PersistentKeepalive)
+	}
+
+	var network strings.Builder
+	network.WriteString("[Match]\n")
+	fmt.Fprintf(&network, "Name=%s\n\n", clientInterface)
+	network.WriteString("[Network]\n")
+	for _, a := range cc.Addresses {
+		fmt.Fprintf(&network, "Address=%s\n", a)
+	}
+	for _, d := range cc.DNS {
+		fmt.Fprintf(&network, "DNS=%s\n", d)
+	}
+	// Unlike wg-quick, networkd does not derive routes from AllowedIPs
+	for _, a := range cc.AllowedIPs {
+		fmt.Fprintf(&network, "\n[Route]\nDestination=%s\n", a)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct{ name, content string }{
+		{clientInterface + ".netdev", netdev.String()},
+		{clientInterface + ".network", network.String()},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderRouterOS produces a RouterOS v7 script to paste into the terminal or run with /import.
+func renderRouterOS(cc ClientConfig) string {
+	const iface = "wireguard-ui"
+	var b strings.Builder
+	b.WriteString("# RouterOS v7 WireGuard client\n")
+	if cc.Name != "" {
+		fmt.Fprintf(&b, "# %s\n", cc.Name)
+	}
+	fmt.Fprintf(&b, "/interface wireguard add name=%s mtu=%d private-key=\"%s\"\n", iface, cc.MTU, cc.PrivateKey)
+	peer := fmt.Sprintf("/interface wireguard peers add interface=%s public-key=\"%s\" endpoint-address=%s endpoint-port=%d allowed-address=%s",
+		iface, cc.ServerPublicKey, cc.EndpointHost, cc.EndpointPort, strings.Join(cc.AllowedIPs, ","))
+	if cc.PresharedKey != "" {
+		peer += fmt.Sprintf(" preshared-key=\"%s\"", cc.PresharedKey)
+	}
+	if cc.PersistentKeepalive > 0 {
+		peer += fmt.Sprintf(" persistent-keepalive=%ds", cc.PersistentKeepalive)
+	}
+	b.WriteString(peer + "\n")
+	v4, v6 := splitAddrs(cc.Addresses)
+	for _, a := range v4 {
+		fmt.Fprintf(&b, "/ip address add address=%s interface=%s\n", a, iface)
+	}
+	for _, a := range v6 {
+		fmt.Fprintf(&b, "/ipv6 address add address=%s interface=%s advertise=no\n", a, iface)
+	}
+	// Addresses are host routes (/32, /128), so the tunneled networks need explicit routes
+	routes4, routes6 := splitAddrs(cc.AllowedIPs)
+	for _, a := range routes4 {
+		fmt.Fprintf(&b, "/ip route add dst-address=%s gateway=%s\n", a, iface)
+	}
+	for _, a := range routes6 {
+		fmt.Fprintf(&b, "/ipv6 route add dst-address=%s gateway=%s\n", a, iface)
+	}
+	return b.String()
+}
+
+// renderOpenWrt produces the interface and peer sections for /etc/config/network.
+func renderOpenWrt(cc ClientConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "config interface '%s'\n", clientInterface)
+	b.WriteString("\toption proto 'wireguard'\n")
+	fmt.Fprintf(&b, "\toption private_key '%s'\n", cc.PrivateKey)
+	fmt.Fprintf(&b, "\toption mtu '%d'\n", cc.MTU)
+	for _, a := range cc.Addresses {
+		fmt.Fprintf(&b, "\tlist addresses '%s'\n", a)
+	}
+	for _, d := range cc.DNS {
+		fmt.Fprintf(&b, "\tlist dns '%s'\n", d)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "config wireguard_%s\n", clientInterface)
+	if cc.Name != "" {
+		fmt.Fprintf(&b, "\toption description '%s'\n", strings.ReplaceAll(cc.Name, "'", ""))
+	}
+	fmt.Fprintf(&b, "\toption public_key '%s'\n", cc.ServerPublicKey)
+	if cc.PresharedKey != "" {
+		fmt.Fprintf(&b, "\toption preshared_key '%s'\n", cc.PresharedKey)
+	}
+	fmt.Fprintf(&b, "\toption endpoint_host '%s'\n", cc.EndpointHost)
+	fmt.Fprintf(&b, "\toption endpoint_port '%d'\n", cc.EndpointPort)
+	if cc.PersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "\toption persistent_keepalive '%d'\n", cc.PersistentKeepalive)
+	}
+	b.WriteString("\toption route_allowed_ips '1'\n")
+	for _, a := range cc.AllowedIPs {
+		fmt.Fprintf(&b, "\tlist allowed_ips '%s'\n", a)
+	}
+	return b.String()
+}
@@ -5,12 +5,21 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/StellaShiina/wireguard-ui/config"
 	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/metrics"
 	"github.com/StellaShiina/wireguard-ui/netutil"
 )
 
+// DefaultMTU is used in client configs unless the peer or its group sets one.
+const DefaultMTU = 1420
+
+// PrivateKeyPlaceholder stands in for the private key of bring-your-own-key peers.
+const PrivateKeyPlaceholder = "<YOUR_PRIVATE_KEY>"
+
 func ensureDirs(cfg *config.Config) error {
 	if err := os.MkdirAll(cfg.WGConfDir, 0o755); err != nil {
 		return err
@@ -21,13 +30,52 @@ func ensureDirs(cfg *config.Config) error {
 	return nil
 }
 
-func GenerateServerConfig(cfg *config.Config, s db.Server, peers []db.Peer) error {
+// File is a generated server-side file: its name in WG_CONF_DIR, content and permissions.
+type File struct {
+	Name    string      `json:"name"`
+	Content string      `json:"content"`
+	Mode    os.FileMode `json:"mode"`
+}
+
+// GenerateServerConfig writes the server config of cfg's interface and the ACL and shaping scripts it runs.
+// Nothing is written for a remote interface: its node agent fetches the files (see RenderServerFiles).
+func GenerateServerConfig(cfg *config.Config, s db.Server, peers []db.Peer) (err error) {
+	if cfg.Remote() {
+		return nil
+	}
+	defer func(start time.Time) {
+		metrics.ObserveGenerate(start, err)
+		if err == nil {
+			events.Publish(events.Event{Type: events.ConfigWritten, Interface: cfg.WGInterface})
+		}
+	}(time.Now())
+	files, err := RenderServerFiles(cfg, s, peers)
+	if err != nil {
+		return err
+	}
+	return WriteFiles(cfg, files)
+}
+
+// WriteFiles writes generated files to WG_CONF_DIR.
+func WriteFiles(cfg *config.Config, files []File) error {
 	if err := ensureDirs(cfg); err != nil {
 		return err
 	}
-	// Detect external interface if not set
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(cfg.WGConfDir, f.Name), []byte(f.Content), f.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderServerFiles renders the server config of cfg's interface and the ACL and shaping scripts it runs
+// from PostUp/PostDown, scripts first. Paths and the NAT interface come from cfg, so a node agent's
+// files are rendered with its own settings.
+func RenderServerFiles(cfg *config.Config, s db.Server, peers []db.Peer) ([]File, error) {
+	// Detect external interface if not set; a node agent reports its own
 	extIF := cfg.WGExternalIF
-	if extIF == "" {
+	if extIF == "" && !cfg.Remote() {
 		if ifname, err := netutil.DetectDefaultInterface(); err == nil {
 			extIF = ifname
 		}
@@ -42,27 +90,31 @@ func GenerateServerConfig(cfg *config.Config, s db.Server, peers []db.Peer) erro
 	}
 	content += fmt.Sprintf("ListenPort = %d\n", s.Port)
 	content += fmt.Sprintf("PrivateKey = %s\n", s.PrivateKey)
-	// PostUp/PostDown rules for NAT and forwarding using detected interface
+	// Forwarding is filtered by the ACL chain instead of blanket FORWARD accepts
+	content += fmt.Sprintf("PostUp   = sh %s up\n", ACLScriptPath(cfg))
+	content += fmt.Sprintf("PostDown = sh %s down\n", ACLScriptPath(cfg))
+	content += fmt.Sprintf("PostUp   = sh %s up\n", ShapingScriptPath(cfg))
+	content += fmt.Sprintf("PostDown = sh %s down\n", ShapingScriptPath(cfg))
+	// PostUp/PostDown rules for NAT using detected interface
 	if extIF != "" {
-		content += fmt.Sprintf("PostUp   = iptables -A FORWARD -i %%i -j ACCEPT\n")
-		content += fmt.Sprintf("PostUp   = iptables -A FORWARD -o %%i -j ACCEPT\n")
 		content += fmt.Sprintf("PostUp   = iptables -t nat -A POSTROUTING -o %s -j MASQUERADE\n", extIF)
-		content += fmt.Sprintf("PostUp   = ip6tables -A FORWARD -i %%i -j ACCEPT\n")
-		content += fmt.Sprintf("PostUp   = ip6tables -A FORWARD -o %%i -j ACCEPT\n")
 		content += fmt.Sprintf("PostUp   = ip6tables -t nat -A POSTROUTING -o %s -j MASQUERADE\n", extIF)
-		content += fmt.Sprintf("PostDown = iptables -D FORWARD -i %%i -j ACCEPT\n")
-		content += fmt.Sprintf("PostDown = iptables -D FORWARD -o %%i -j ACCEPT\n")
 		content += fmt.Sprintf("PostDown = iptables -t nat -D POSTROUTING -o %s -j MASQUERADE\n", extIF)
-		content += fmt.Sprintf("PostDown = ip6tables -D FORWARD -i %%i -j ACCEPT\n")
-		content += fmt.Sprintf("PostDown = ip6tables -D FORWARD -o %%i -j ACCEPT\n")
 		content += fmt.Sprintf("PostDown = ip6tables -t nat -D POSTROUTING -o %s -j MASQUERADE\n\n", extIF)
 	} else {
 		content += "\n" // keep spacing even if extIF undetected
 	}
 
+	now := time.Now()
 	for _, p := range peers {
+		if !p.Active(now) {
+			continue
+		}
 		content += "[Peer]\n"
 		content += fmt.Sprintf("PublicKey = %s\n", p.PublicKey)
+		if p.PresharedKey != nil && *p.PresharedKey != "" {
+			content += fmt.Sprintf("PresharedKey = %s\n", *p.PresharedKey)
+		}
 		// AllowedIPs include peer IPv4 and IPv6 if present
 		if p.IPv6 != nil && *p.IPv6 != "" {
 			content += fmt.Sprintf("AllowedIPs = %s\n", valOrEmpty(p.IPv4))
@@ -72,45 +124,121 @@ func GenerateServerConfig(cfg *config.Config, s db.Server, peers []db.Peer) erro
 		}
 	}
 
-	filename := cfg.WGInterface + ".conf"
-
-	path := filepath.Join(cfg.WGConfDir, filename)
-	return os.WriteFile(path, []byte(content), 0o644)
+	acl, err := renderACLScript(cfg, peers)
+	if err != nil {
+		return nil, err
+	}
+	return []File{
+		{Name: filepath.Base(ACLScriptPath(cfg)), Content: acl, Mode: 0o755},
+		{Name: filepath.Base(ShapingScriptPath(cfg)), Content: RenderShapingScript(cfg.WGInterface, peers), Mode: 0o755},
+		{Name: cfg.WGInterface + ".conf", Content: content, Mode: 0o644},
+	}, nil
 }
 
+// GeneratePeerConfig renders the client config and writes it to WGClientsDir, returning its path.
 func GeneratePeerConfig(cfg *config.Config, s db.Server, p db.Peer) (string, error) {
 	if err := ensureDirs(cfg); err != nil {
 		return "", err
 	}
+	path := filepath.Join(cfg.WGClientsDir, fmt.Sprintf("%s.conf", p.UUID))
+	return path, os.WriteFile(path, []byte(RenderPeerConfig(s, p)), 0o644)
+}
+
+// RenderPeerConfig returns the wg-quick client config of a peer.
+func RenderPeerConfig(s db.Server, p db.Peer) string {
+	cc := BuildClientConfig(s, p)
 	content := "[Interface]\n"
-	content += fmt.Sprintf("PrivateKey = %s\n", p.PrivateKey)
-	// Address: include IPv4 and optionally IPv6
-	if s.EnableIPv6 && p.IPv6 != nil && *p.IPv6 != "" {
-		content += fmt.Sprintf("Address = %s, %s\n", valOrEmpty(p.IPv4), valOrEmpty(p.IPv6))
-	} else {
-		content += fmt.Sprintf("Address = %s\n", valOrEmpty(p.IPv4))
+	content += fmt.Sprintf("PrivateKey = %s\n", cc.PrivateKey)
+	content += fmt.Sprintf("Address = %s\n", strings.Join(cc.Addresses, ", "))
+	if len(cc.DNS) > 0 {
+		content += fmt.Sprintf("DNS = %s\n", strings.Join(cc.DNS, ", "))
 	}
-	content += "MTU = 1420\n\n"
+	content += fmt.Sprintf("MTU = %d\n\n", cc.MTU)
 
 	content += "[Peer]\n"
-	content += fmt.Sprintf("PublicKey = %s\n", s.PublicKey)
-	var endpointIP string
-	if strings.Contains(s.PublicIP, ":") {
-		endpointIP = "[" + s.PublicIP + "]"
-	} else {
-		endpointIP = s.PublicIP
+	content += fmt.Sprintf("PublicKey = %s\n", cc.ServerPublicKey)
+	if cc.PresharedKey != "" {
+		content += fmt.Sprintf("PresharedKey = %s\n", cc.PresharedKey)
 	}
-	content += fmt.Sprintf("Endpoint = %s:%d\n", endpointIP, s.Port)
-	// Route only the server subnets through the tunnel; rely on server-side NAT
-	if s.EnableIPv6 && s.SubnetV6 != "" {
-		content += fmt.Sprintf("AllowedIPs = %s\n", s.SubnetV4)
-		content += fmt.Sprintf("AllowedIPs = %s\n", s.SubnetV6)
-	} else {
-		content += fmt.Sprintf("AllowedIPs = %s\n", s.SubnetV4)
+	content += fmt.Sprintf("Endpoint = %s\n", cc.Endpoint)
+	for _, a := range cc.AllowedIPs {
+		content += fmt.Sprintf("AllowedIPs = %s\n", a)
 	}
+	if cc.PersistentKeepalive > 0 {
+		content += fmt.Sprintf("PersistentKeepalive = %d\n", cc.PersistentKeepalive)
+	}
+	return content
+}
 
-	path := filepath.Join(cfg.WGClientsDir, fmt.Sprintf("%s.conf", p.UUID))
-	return path, os.WriteFile(path, []byte(content), 0o644)
+// Interface is one managed interface: its server record and the config pointing at it.
+type Interface struct {
+	Cfg    *config.Config
+	Server db.Server
+}
+
+// Interfaces returns every managed interface, local and remote, ordered by name.
+func Interfaces(cfg *config.Config) ([]Interface, error) {
+	servers, err := db.Servers()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Interface, len(servers))
+	for i, s := range servers {
+		out[i] = Interface{Cfg: ConfigFor(cfg, s), Server: s}
+	}
+	return out, nil
+}
+
+// ConfigFor returns the config pointing at the interface of s, marked remote when a node agent runs it.
+func ConfigFor(cfg *config.Config, s db.Server) *config.Config {
+	ifc := cfg.ForInterface(s.Interface)
+	if s.NodeUUID != nil {
+		ifc.WGNode = *s.NodeUUID
+	}
+	return ifc
+}
+
+// WriteInterfaceConfigs reloads the peers of one interface from the database and rewrites its server
+// config and their client configs. cfg must point at the interface (see config.ForInterface).
+func WriteInterfaceConfigs(cfg *config.Config, s db.Server) error {
+	var peers []db.Peer
+	if err := db.DB.Scopes(db.OnServer(s.UUID)).Preload("Group").Find(&peers).Error; err != nil {
+		return err
+	}
+	if err := GenerateServerConfig(cfg, s, peers); err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if _, err := GeneratePeerConfig(cfg, s, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAllConfigs rewrites the configuration files of every interface.
+func WriteAllConfigs(cfg *config.Config) error {
+	ifaces, err := Interfaces(cfg)
+	if err != nil {
+		return err
+	}
+	if len(ifaces) == 0 {
+		return fmt.Errorf("server not initialized")
+	}
+	for _, ifc := range ifaces {
+		if err := WriteInterfaceConfigs(ifc.Cfg, ifc.Server); err != nil {
+			return fmt.Errorf("%s: %w", ifc.Server.Interface, err)
+		}
+	}
+	return nil
+}
+
+// Endpoint returns the server's public host:port, bracketing IPv6 addresses.
+func Endpoint(s db.Server) string {
+	if strings.Contains(s.PublicIP, ":") {
+		return fmt.Sprintf("[%s]:%d", s.PublicIP, s.Port)
+	}
+	return fmt.Sprintf("%s:%d", s.PublicIP, s.Port)
 }
 
 func valOrEmpty(v *string) string {
@@ -2,6 +2,7 @@ package wireguard
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"os/exec"
 	"strings"
@@ -25,16 +26,45 @@ func GenerateKeyPair() (privateKey, publicKey string, err error) {
 		return "", "", errors.New("empty private key from wg genkey")
 	}
 
-	// Derive public key
+	pubKey, err := DerivePublicKey(priv)
+	if err != nil {
+		return "", "", err
+	}
+	return priv, pubKey, nil
+}
+
+// DerivePublicKey returns the public key of a private key with `wg pubkey`, passing it via stdin.
+func DerivePublicKey(privateKey string) (string, error) {
+	cfg := config.LoadConfig()
 	pub := exec.Command(cfg.WGMode, "pubkey")
-	pub.Stdin = bytes.NewReader([]byte(priv))
+	pub.Stdin = bytes.NewReader([]byte(privateKey))
 	pubOut, err := pub.Output()
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 	pubKey := strings.TrimSpace(string(pubOut))
 	if pubKey == "" {
-		return "", "", errors.New("empty public key from wg pubkey")
+		return "", errors.New("empty public key from wg pubkey")
 	}
-	return priv, pubKey, nil
+	return pubKey, nil
+}
+
+// ValidKey reports whether k looks like a WireGuard key: base64 encoding of exactly 32 bytes.
+func ValidKey(k string) bool {
+	raw, err := base64.StdEncoding.DecodeString(k)
+	return err == nil && len(raw) == 32
+}
+
+// GeneratePresharedKey uses `wg genpsk` to produce a new preshared key.
+func GeneratePresharedKey() (string, error) {
+	cfg := config.LoadConfig()
+	out, err := exec.Command(cfg.WGMode, "genpsk").Output()
+	if err != nil {
+		return "", err
+	}
+	psk := strings.TrimSpace(string(out))
+	if psk == "" {
+		return "", errors.New("empty preshared key from wg genpsk")
+	}
+	return psk, nil
 }
@@ -0,0 +1,126 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+	"github.com/StellaShiina/wireguard-ui/metrics"
+)
+
+// runWG runs the wg (or awg) tool with optional stdin and returns combined error output on failure.
+func runWG(cfg *config.Config, stdin string, args ...string) (string, error) {
+	cmd := exec.Command(cfg.WGMode, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("%s %s: %v: %s", cfg.WGMode, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out.String(), nil
+}
+
+// InterfaceUp reports whether the configured interface currently exists in the kernel, or for a remote
+// interface, whether its node agent recently reported it up.
+func InterfaceUp(cfg *config.Config) bool {
+	if cfg.Remote() {
+		st, ok := RemoteStatus(cfg)
+		return ok && st.Up
+	}
+	_, err := runWG(cfg, "", "show", cfg.WGInterface)
+	return err == nil
+}
+
+// RemovePeer drops a peer from the running interface without touching the others.
+func RemovePeer(cfg *config.Config, publicKey string) error {
+	if cfg.Remote() {
+		return nil
+	}
+	start := time.Now()
+	_, err := runWG(cfg, "", "set", cfg.WGInterface, "peer", publicKey, "remove")
+	metrics.ObserveApply("peer", start, err)
+	return err
+}
+
+// SetPeer adds or updates a peer on the running interface with the same settings as its [Peer] section.
+func SetPeer(cfg *config.Config, p db.Peer) error {
+	if cfg.Remote() {
+		return nil
+	}
+	var allowed []string
+	for _, a := range []*string{p.IPv4, p.IPv6} {
+		if a != nil && *a != "" {
+			allowed = append(allowed, *a)
+		}
+	}
+	args := []string{"set", cfg.WGInterface, "peer", p.PublicKey, "allowed-ips", strings.Join(allowed, ",")}
+	stdin := ""
+	if p.PresharedKey != nil && *p.PresharedKey != "" {
+		// wg only reads preshared keys from files; pass it through stdin instead of the command line
+		args = append(args, "preshared-key", "/dev/stdin")
+		stdin = *p.PresharedKey
+	}
+	start := time.Now()
+	_, err := runWG(cfg, stdin, args...)
+	metrics.ObserveApply("peer", start, err)
+	return err
+}
+
+// SyncConf loads the config file of cfg's interface into the running interface with `wg syncconf`: peers
+// are added, updated and removed in place and the private key and listen port follow the file, while the
+// sessions of unchanged peers survive. The wg-quick lines (Address, PostUp, ...) are dropped with
+// `wg-quick strip` first, so address changes still need a restart.
+func SyncConf(cfg *config.Config) (err error) {
+	if cfg.Remote() {
+		return nil
+	}
+	defer func(start time.Time) { metrics.ObserveApply("syncconf", start, err) }(time.Now())
+	quick := cfg.WGMode + "-quick"
+	cmd := exec.Command(quick, "strip", filepath.Join(cfg.WGConfDir, cfg.WGInterface+".conf"))
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s strip: %v: %s", quick, err, strings.TrimSpace(stderr.String()))
+	}
+	// The stripped config carries the private key; like preshared keys, pass it through stdin
+	_, err = runWG(cfg, out.String(), "syncconf", cfg.WGInterface, "/dev/stdin")
+	return err
+}
+
+// reloadScript runs the up action of a generated script on the running interface and reports it under kind
+// in the apply metrics and as a config.applied event. A down interface is skipped, since wg-quick runs the
+// script from PostUp on the next start, and so is a remote one, whose node agent runs it.
+func reloadScript(cfg *config.Config, kind, path string) (err error) {
+	if cfg.Remote() || !InterfaceUp(cfg) {
+		return nil
+	}
+	defer func(start time.Time) {
+		metrics.ObserveApply(kind, start, err)
+		if err == nil {
+			events.Publish(events.Event{Type: events.ConfigApplied, Interface: cfg.WGInterface, Data: map[string]any{"kind": kind}})
+		}
+	}(time.Now())
+	return runScript(path, "up")
+}
+
+// runScript runs one of the generated helper scripts with the given action.
+func runScript(path, action string) error {
+	cmd := exec.Command("sh", path, action)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", filepath.Base(path), action, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
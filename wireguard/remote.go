@@ -0,0 +1,58 @@
+package wireguard
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+)
+
+// ReportTTL is how long a node agent's report stands for the state of its interfaces; an interface whose
+// agent has been silent for longer counts as down.
+const ReportTTL = 2 * time.Minute
+
+// RemoteState is what a node agent last reported about one of its interfaces.
+type RemoteState struct {
+	Up           bool
+	ServiceState string
+	Dump         *Dump
+	Error        string
+	ReportedAt   time.Time
+}
+
+var remote = struct {
+	sync.Mutex
+	state map[string]RemoteState
+}{state: map[string]RemoteState{}}
+
+// ReportRemote records the state of an interface as reported by its node agent.
+func ReportRemote(iface string, st RemoteState) {
+	remote.Lock()
+	defer remote.Unlock()
+	remote.state[iface] = st
+}
+
+// RemoteStatus returns the last report on cfg's interface, or false when there is none within ReportTTL.
+func RemoteStatus(cfg *config.Config) (RemoteState, bool) {
+	remote.Lock()
+	defer remote.Unlock()
+	st, ok := remote.state[cfg.WGInterface]
+	if !ok || time.Since(st.ReportedAt) > ReportTTL {
+		return RemoteState{}, false
+	}
+	return st, true
+}
+
+func remoteDump(cfg *config.Config) (*Dump, error) {
+	st, ok := RemoteStatus(cfg)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%s: no recent report from node agent", cfg.WGInterface)
+	case !st.Up || st.Dump == nil:
+		return nil, fmt.Errorf("%s: interface is down on its node", cfg.WGInterface)
+	}
+	d := *st.Dump
+	d.Peers = append([]PeerDump(nil), st.Dump.Peers...)
+	return &d, nil
+}
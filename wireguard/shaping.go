@@ -0,0 +1,181 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+)
+
+// ShapingScriptPath is the shell script that installs (up) or removes (down) the per-peer rate limits.
+func ShapingScriptPath(cfg *config.Config) string {
+	return filepath.Join(cfg.WGConfDir, cfg.WGInterface+"-tc.sh")
+}
+
+// RenderShapingScript compiles the rate limits of the active peers into a tc script.
+//
+// Download (server to peer) leaves through the interface and is shaped by one HTB class per peer,
+// selected by destination address; unclassified traffic bypasses shaping. Upload (peer to server)
+// can only be policed: packets above the rate are dropped by an ingress filter on the source address.
+//
+// The limits are installed as one of two generations, each with its own class IDs and filter priorities.
+// A reload builds the new generation next to the running one and only then removes the old one, so the
+// limits never lapse, and a reload that fails leaves the old generation in place.
+func RenderShapingScript(iface string, peers []db.Peer) string {
+	type limit struct {
+		class int // HTB class of the download limit, 0 for none
+		down  int
+		up    int
+		addrs []netip.Prefix
+	}
+	var limits []limit
+	now := time.Now()
+	class := 0
+	for _, p := range peers {
+		if !p.Active(now) {
+			continue
+		}
+		l := limit{addrs: peerAddrs(p)}
+		// Each generation has 0x7fff class IDs
+		if p.RateDownKbit != nil && *p.RateDownKbit > 0 && class < 0x7fff {
+			class++
+			l.class, l.down = class, *p.RateDownKbit
+		}
+		if p.RateUpKbit != nil && *p.RateUpKbit > 0 {
+			l.up = *p.RateUpKbit
+		}
+		if l.class != 0 || l.up != 0 {
+			limits = append(limits, l)
+		}
+	}
+
+	// batch renders the tc commands of a generation: its class IDs start at 1 or 0x8001, its IPv4 and IPv6
+	// filters use priorities 1 and 2 or 3 and 4 (a priority holds a single protocol)
+	batch := func(gen int) []string {
+		base, prio := 0, map[bool]int{false: 1, true: 2}
+		if gen == 2 {
+			base, prio = 0x8000, map[bool]int{false: 3, true: 4}
+		}
+		var cmds []string
+		for _, l := range limits {
+			if l.class != 0 {
+				cmds = append(cmds, fmt.Sprintf("class add dev %s parent 1: classid 1:%x htb rate %dkbit ceil %dkbit burst %d", iface, base+l.class, l.down, l.down, shapingBurst(l.down)))
+			}
+			for _, a := range l.addrs {
+				v6 := a.Addr().Is6()
+				proto, match := "ip", "ip"
+				if v6 {
+					proto, match = "ipv6", "ip6"
+				}
+				if l.class != 0 {
+					cmds = append(cmds, fmt.Sprintf("filter add dev %s parent 1: protocol %s prio %d u32 match %s dst %s flowid 1:%x", iface, proto, prio[v6], match, a, base+l.class))
+				}
+				if l.up != 0 {
+					cmds = append(cmds, fmt.Sprintf("filter add dev %s parent ffff: protocol %s prio %d u32 match %s src %s police rate %dkbit burst %d drop flowid :1", iface, proto, prio[v6], match, a, l.up, shapingBurst(l.up)))
+				}
+			}
+		}
+		return cmds
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by wireguard-ui from the peer rate limits in the database; manual changes are overwritten.\n")
+	fmt.Fprintf(&b, "# Usage: %s-tc.sh up|down\n", iface)
+	fmt.Fprintf(&b, "IFACE=%s\n\n", iface)
+
+	b.WriteString("down() {\n")
+	b.WriteString("\ttc qdisc del dev \"$IFACE\" root 2>/dev/null\n")
+	b.WriteString("\ttc qdisc del dev \"$IFACE\" ingress 2>/dev/null\n")
+	b.WriteString("\treturn 0\n")
+	b.WriteString("}\n\n")
+
+	if len(limits) == 0 {
+		b.WriteString("up() {\n")
+		b.WriteString("\t# No peer has a rate limit\n")
+		b.WriteString("\tdown\n")
+		b.WriteString("}\n\n")
+	} else {
+		b.WriteString("# batch prints the tc commands of generation $1\n")
+		b.WriteString("batch() {\n")
+		for gen := 1; gen <= 2; gen++ {
+			if gen == 1 {
+				b.WriteString("\tif [ \"$1\" = 1 ]; then\n")
+			} else {
+				b.WriteString("\telse\n")
+			}
+			b.WriteString("\t\tcat <<'EOF'\n")
+			for _, c := range batch(gen) {
+				b.WriteString(c + "\n")
+			}
+			b.WriteString("EOF\n")
+		}
+		b.WriteString("\tfi\n")
+		b.WriteString("}\n\n")
+
+		b.WriteString("# drop removes the filters and classes of generation $1\n")
+		b.WriteString("drop() {\n")
+		b.WriteString("\tfor prio in $(($1 * 2 - 1)) $(($1 * 2)); do\n")
+		b.WriteString("\t\ttc filter del dev \"$IFACE\" parent 1: prio \"$prio\" 2>/dev/null\n")
+		b.WriteString("\t\ttc filter del dev \"$IFACE\" parent ffff: prio \"$prio\" 2>/dev/null\n")
+		b.WriteString("\tdone\n")
+		b.WriteString("\tfor id in $(tc class show dev \"$IFACE\" | awk '$2 == \"htb\" { print $3 }'); do\n")
+		b.WriteString("\t\tcase \"${id#1:}\" in\n")
+		b.WriteString("\t\t[89a-f]???) gen=2 ;;\n")
+		b.WriteString("\t\t*) gen=1 ;;\n")
+		b.WriteString("\t\tesac\n")
+		b.WriteString("\t\t[ \"$gen\" = \"$1\" ] && tc class del dev \"$IFACE\" classid \"$id\"\n")
+		b.WriteString("\tdone\n")
+		b.WriteString("\treturn 0\n")
+		b.WriteString("}\n\n")
+
+		b.WriteString("up() {\n")
+		b.WriteString("\tif tc filter show dev \"$IFACE\" parent 1: 2>/dev/null | grep -q ' pref [12] ' ||\n")
+		b.WriteString("\t\ttc filter show dev \"$IFACE\" parent ffff: 2>/dev/null | grep -q ' pref [12] '; then\n")
+		b.WriteString("\t\told=1 new=2\n")
+		b.WriteString("\telse\n")
+		b.WriteString("\t\told=2 new=1\n")
+		b.WriteString("\tfi\n")
+		b.WriteString("\ttc qdisc show dev \"$IFACE\" root | grep -q '^qdisc htb 1: ' ||\n")
+		b.WriteString("\t\ttc qdisc replace dev \"$IFACE\" root handle 1: htb || return 1\n")
+		b.WriteString("\ttc qdisc show dev \"$IFACE\" ingress | grep -q '^qdisc ingress ffff: ' ||\n")
+		b.WriteString("\t\ttc qdisc add dev \"$IFACE\" handle ffff: ingress || return 1\n")
+		b.WriteString("\t# Leftovers of an interrupted reload\n")
+		b.WriteString("\tdrop \"$new\"\n")
+		b.WriteString("\tif ! batch \"$new\" | tc -batch -; then\n")
+		b.WriteString("\t\tdrop \"$new\"\n")
+		b.WriteString("\t\treturn 1\n")
+		b.WriteString("\tfi\n")
+		b.WriteString("\tdrop \"$old\"\n")
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("case \"$1\" in\n")
+	b.WriteString("up) up ;;\n")
+	b.WriteString("down) down ;;\n")
+	b.WriteString("*) echo \"usage: $0 up|down\" >&2; exit 2 ;;\n")
+	b.WriteString("esac\n")
+	return b.String()
+}
+
+// shapingBurst returns a burst size in bytes of about 100ms at the given rate, and at least a few full-size packets.
+func shapingBurst(rateKbit int) int {
+	burst := rateKbit * 1000 / 8 / 10
+	if burst < 15000 {
+		burst = 15000
+	}
+	return burst
+}
+
+// ApplyShaping reloads the per-peer rate limits on the running interface; the script swaps in the new
+// limits without a gap (see RenderShapingScript).
+func ApplyShaping(cfg *config.Config) error {
+	if err := reloadScript(cfg, "shaping", ShapingScriptPath(cfg)); err != nil {
+		return fmt.Errorf("apply rate limits: %w", err)
+	}
+	return nil
+}
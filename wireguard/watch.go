@@ -0,0 +1,133 @@
+package wireguard
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/StellaShiina/wireguard-ui/config"
+	"github.com/StellaShiina/wireguard-ui/db"
+	"github.com/StellaShiina/wireguard-ui/events"
+)
+
+// ifaceState is the state last published per interface, shared by the watcher and NoteInterface.
+var ifaceState = struct {
+	sync.Mutex
+	up map[string]bool
+}{up: map[string]bool{}}
+
+// NoteInterface publishes interface.up or interface.down if the state differs from the last one seen.
+// The panel calls it right after starting or stopping the service instead of waiting for the watcher.
+func NoteInterface(cfg *config.Config, up bool) {
+	ifaceState.Lock()
+	prev, known := ifaceState.up[cfg.WGInterface]
+	changed := known && prev != up
+	ifaceState.up[cfg.WGInterface] = up
+	ifaceState.Unlock()
+	if !changed {
+		return
+	}
+	typ := events.InterfaceDown
+	if up {
+		typ = events.InterfaceUp
+	}
+	events.Publish(events.Event{Type: typ, Interface: cfg.WGInterface})
+}
+
+// runtimeState is what the watcher saw on one interface in its previous pass.
+type runtimeState struct {
+	handshakes map[string]time.Time
+	online     map[string]bool
+}
+
+// WatchRuntime polls every managed interface and publishes interface up/down transitions, new handshakes
+// and peers going offline. The first pass over an interface only records its current state.
+func WatchRuntime(cfg *config.Config, interval time.Duration) {
+	states := map[string]*runtimeState{}
+	for {
+		ifaces, err := Interfaces(cfg)
+		if err != nil {
+			log.Printf("[WG] runtime watch: query interfaces failed: %v", err)
+		}
+		for _, ifc := range ifaces {
+			st, primed := states[ifc.Server.Interface]
+			if !primed {
+				st = &runtimeState{handshakes: map[string]time.Time{}, online: map[string]bool{}}
+				states[ifc.Server.Interface] = st
+			}
+			watchInterface(ifc.Cfg, st, primed)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func watchInterface(cfg *config.Config, st *runtimeState, primed bool) {
+	dump, err := ShowDump(cfg)
+	NoteInterface(cfg, err == nil)
+	if err != nil {
+		// Sessions do not survive a restart; peers come back with a new handshake
+		st.online = map[string]bool{}
+		return
+	}
+	now := time.Now()
+	var seen, gone []PeerDump
+	present := map[string]bool{}
+	for _, p := range dump.Peers {
+		present[p.PublicKey] = true
+		if p.LatestHandshake != nil {
+			if prev, ok := st.handshakes[p.PublicKey]; !ok || p.LatestHandshake.After(prev) {
+				st.handshakes[p.PublicKey] = *p.LatestHandshake
+				seen = append(seen, p)
+			}
+		}
+		on := p.Online(now)
+		if st.online[p.PublicKey] && !on {
+			gone = append(gone, p)
+		}
+		st.online[p.PublicKey] = on
+	}
+	for key := range st.handshakes {
+		if !present[key] {
+			delete(st.handshakes, key)
+		}
+	}
+	for key := range st.online {
+		if !present[key] {
+			delete(st.online, key)
+		}
+	}
+	if primed {
+		publishRuntime(cfg, seen, gone)
+	}
+}
+
+func publishRuntime(cfg *config.Config, seen, gone []PeerDump) {
+	if len(seen) == 0 && len(gone) == 0 {
+		return
+	}
+	var keys []string
+	for _, p := range append(append([]PeerDump{}, seen...), gone...) {
+		keys = append(keys, p.PublicKey)
+	}
+	var peers []db.Peer
+	if err := db.DB.Select("uuid", "name", "public_key").Where("public_key IN ?", keys).Find(&peers).Error; err != nil {
+		log.Printf("[WG] runtime watch: query peers failed: %v", err)
+		return
+	}
+	byKey := map[string]db.Peer{}
+	for _, p := range peers {
+		byKey[p.PublicKey] = p
+	}
+	for _, p := range seen {
+		if dbp, ok := byKey[p.PublicKey]; ok {
+			events.Publish(events.Event{Type: events.PeerHandshake, Interface: cfg.WGInterface, PeerUUID: dbp.UUID,
+				Data: map[string]any{"name": dbp.Name, "endpoint": p.Endpoint, "latest_handshake": p.LatestHandshake}})
+		}
+	}
+	for _, p := range gone {
+		if dbp, ok := byKey[p.PublicKey]; ok {
+			events.Publish(events.Event{Type: events.PeerOffline, Interface: cfg.WGInterface, PeerUUID: dbp.UUID,
+				Data: map[string]any{"name": dbp.Name, "latest_handshake": p.LatestHandshake}})
+		}
+	}
+}